@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// batch converts many files in one run, counting failures. Failures are
+// reported and skipped unless strict is set.
+type batch struct {
+	conv          *converter
+	strict        bool
+	jobs          int // files converted at once
+	total, failed int
+	todo          []job
+}
+
+// job is one file of a batch and where its shader goes.
+type job struct {
+	path, outPath string
+}
+
+// outcome is what converting one job produced. fatal is set when the
+// batch cannot go on (the output directory could not be created).
+type outcome struct {
+	res   result
+	err   error
+	fatal error
+	done  bool
+}
+
+// add queues a file for run.
+func (b *batch) add(path, outPath string) {
+	b.todo = append(b.todo, job{path, outPath})
+}
+
+// run converts the queued files on b.jobs workers, then reports them in
+// filename order, so the output does not depend on which finished first.
+// With strict set, no new file is started after one fails, and files after
+// the first failure are not reported; any already in progress still finish.
+// It fails before converting anything if two files share an output path.
+func (b *batch) run() error {
+	slices.SortFunc(b.todo, func(x, y job) int { return strings.Compare(x.path, y.path) })
+	seen := make(map[string]string, len(b.todo))
+	for _, j := range b.todo {
+		out := filepath.Clean(j.outPath)
+		if prev, ok := seen[out]; ok {
+			return fmt.Errorf("%s and %s would both be written to %s", prev, j.path, out)
+		}
+		seen[out] = j.path
+	}
+
+	outs := make([]outcome, len(b.todo))
+	var next atomic.Int64
+	var stop atomic.Bool
+	var wg sync.WaitGroup
+	for range max(1, min(b.jobs, len(b.todo))) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !stop.Load() {
+				i := int(next.Add(1)) - 1
+				if i >= len(b.todo) {
+					return
+				}
+				outs[i] = b.convert(b.todo[i])
+				if outs[i].fatal != nil || (outs[i].err != nil && b.strict) {
+					stop.Store(true)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, o := range outs {
+		if !o.done {
+			continue
+		}
+		if o.fatal != nil {
+			return o.fatal
+		}
+		b.total++
+		if o.err != nil {
+			b.failed++
+			fmt.Fprintf(os.Stderr, "error: %s: %v\n", b.todo[i].path, o.err)
+			if b.strict {
+				break
+			}
+			continue
+		}
+		if !b.conv.quiet {
+			fmt.Println(b.conv.report(o.res))
+		}
+	}
+	return nil
+}
+
+// convert converts one file, creating the output directory as needed.
+func (b *batch) convert(j job) outcome {
+	if !b.conv.dry {
+		if err := os.MkdirAll(filepath.Dir(j.outPath), 0o755); err != nil {
+			return outcome{fatal: err, done: true}
+		}
+	}
+	res, err := b.conv.convert(j.path, j.outPath)
+	return outcome{res: res, err: err, done: true}
+}
+
+// finish prints the totals (unless quiet, or with -json, where each line
+// must stay an object) and returns the process exit code.
+func (b *batch) finish() int {
+	if !b.conv.quiet && !b.conv.json {
+		fmt.Printf("Converted %d of %d files (%d failed)\n", b.total-b.failed, b.total, b.failed)
+	}
+	if b.failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runBatch converts every *.xbm (or *.xbm.gz) below inDir into outDir, mirroring the
+// directory layout. It returns the process exit code.
+func runBatch(conv *converter, inDir, outDir string, strict bool, jobs int) int {
+	b := &batch{conv: conv, strict: strict, jobs: jobs}
+	ext := shaderExt(conv.opts.Godot, conv.include, conv.opts.Mode)
+
+	err := filepath.WalkDir(inDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !isXBMPath(path) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(inDir, path)
+		if err != nil {
+			return err
+		}
+		b.add(path, filepath.Join(outDir, trimExt(rel)+ext))
+		return nil
+	})
+	if err == nil {
+		err = b.run()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	return b.finish()
+}
+
+// isXBMPath reports whether path names an XBM file, gzipped or not.
+func isXBMPath(path string) bool {
+	lower := strings.ToLower(path)
+	return strings.HasSuffix(lower, ".xbm") || strings.HasSuffix(lower, ".xbm.gz")
+}
+
+// isGlob reports whether path contains glob metacharacters.
+func isGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// runGlob converts every file matching pattern. Each shader is written next
+// to its source, or into outDir if set, with the extension swapped; matches
+// from different directories with the same name cannot share outDir. It
+// returns the process exit code.
+func runGlob(conv *converter, pattern, outDir string, strict bool, jobs int) int {
+	matches, err := filepath.Glob(pattern)
+	if err == nil && len(matches) == 0 {
+		err = errors.New("no files match " + pattern)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+
+	b := &batch{conv: conv, strict: strict, jobs: jobs}
+	ext := shaderExt(conv.opts.Godot, conv.include, conv.opts.Mode)
+	for _, path := range matches {
+		outPath := trimExt(path) + ext
+		if outDir != "" {
+			outPath = filepath.Join(outDir, filepath.Base(outPath))
+		}
+		b.add(path, outPath)
+	}
+	if err := b.run(); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	return b.finish()
+}
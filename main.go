@@ -1,225 +1,1518 @@
 // xbm2gdshader - convert XBM files into a self-contained Godot 4 canvas_item shader
-// Usage: go run main.go -in test.xbm -out test.gdshader [-type canvas_item|spatial] [-fg "#000000FF"] [-bg "#00000000"]
+// Usage: go run . -in test.xbm -out test.gdshader [-type canvas_item|spatial] [-fg "#000000FF"] [-bg "#00000000"]
+// Use "-" for -in or -out to read from stdin or write to stdout.
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/fs"
+	"math"
 	"os"
-	"regexp"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/ganehag/xbm2gdshader/xbm"
 )
 
 var version = "0.1.0"
 
-var (
-	// Width/height #defines (any symbol prefix)
-	reW = regexp.MustCompile(`(?m)#define\s+\w+_width\s+(\d+)`)
-	reH = regexp.MustCompile(`(?m)#define\s+\w+_height\s+(\d+)`)
-
-	// Permissive: match "<name>_bits[] = { ... };", ignore qualifiers/types
-	reArr = regexp.MustCompile(`(?s)[A-Za-z_]\w*_bits\[\]\s*=\s*\{(.*?)\};`)
+func main() {
+	if err := run(); err != nil {
+		if !errors.Is(err, errReported) {
+			fmt.Fprintln(os.Stderr, "error:", err)
+		}
+		os.Exit(1)
+	}
+}
 
-	// Accept hex (0x..), decimal; treat bare numbers as decimal
-	reNum = regexp.MustCompile(`0[xX][0-9A-Fa-f]+|\d+`)
-)
+// errReported means the failure has already been printed (per file in
+// batch and glob mode); main only sets the exit status.
+var errReported = errors.New("conversion failed")
 
-func main() {
-	in := flag.String("in", "", "input .xbm file")
-	out := flag.String("out", "out.gdshader", "output .gdshader path")
-	shType := flag.String("type", "canvas_item", "shader type: canvas_item or spatial")
-	fg := flag.String("fg", "#000000FF", "foreground RGBA (hex #RRGGBBAA)")
-	bg := flag.String("bg", "#00000000", "background RGBA (hex #RRGGBBAA)")
+// run is the CLI. It returns an error instead of exiting so that os.Exit
+// stays in main.
+func run() error {
+	in := flag.String("in", "", "input .xbm, two-colour .xpm, .pbm or PNG/GIF/JPEG file (\"-\" for stdin)")
+	out := flag.String("out", "", "output .gdshader path (\"-\" for stdout; default: next to -in, or out.gdshader for stdin)")
+	shType := flag.String("type", "canvas_item", "shader type: canvas_item, spatial or a comma list of both")
+	fg := flag.String("fg", "#000000FF", "foreground colour: hex #RRGGBBAA/#RRGGBB/#RGB or a name like red (default: $XBM_FG if set)")
+	bg := flag.String("bg", "#00000000", "background colour: hex #RRGGBBAA/#RRGGBB/#RGB or a name like transparent (default: $XBM_BG if set)")
+	palette := flag.String("palette", "", "GIMP .gpl palette to pick -fgindex/-bgindex colours from")
+	fgIndex := flag.Int("fgindex", -1, "foreground colour: index into -palette")
+	bgIndex := flag.Int("bgindex", -1, "background colour: index into -palette")
+	discardBG := flag.Bool("discard-bg", false, "discard background fragments instead of drawing the background colour")
+	outline := flag.String("outline", "", "draw a 1px outline of this colour around the foreground (e.g. #FFFFFFFF)")
+	colorSpace := flag.String("colorspace", "", "colour values: srgb or linear (default srgb for canvas_item, linear for spatial)")
+	mode := flag.String("mode", "array", "bitmap storage: array (const uint[]), texture or itexture (companion .png), sdf (distance field .png), rle (run boundaries), raw (packed bytes, no shader), xbm (canonical XBM, no shader), visualshader (VisualShader .tres, Godot 4.1+), or gosource (array-mode shader as a Go string constant)")
+	goPackage := flag.String("package", "", "-mode gosource: Go package name (default: the output directory's name)")
+	maxWords := flag.Int("maxwords", 0, "switch from array to texture mode when DATA would exceed this many words (0 = never)")
+	uniformDims := flag.Bool("uniform-dims", false, "declare WIDTH and HEIGHT as uniforms instead of consts, so DATA can be swapped for another size")
+	annotate := flag.Bool("annotate", false, "array mode: comment each DATA word with the pixels it holds")
+	chunk := flag.Int("chunk", 0, "array mode: split DATA into DATA0, DATA1, ... of at most this many words each (0 = one array)")
+	pack := flag.String("pack", "uint", "array mode element type: uint, int, u64 (uvec2 pairs, two words per element) or uvec4 (four words per element)")
+	uvSource := flag.String("uvsource", "screen", "sampling coordinates: screen (pixel-locked) or uv (mesh UVs)")
+	scale := flag.Int("scale", 1, "screen pixels per bitmap pixel along each axis")
+	wrap := flag.String("wrap", "tile", "outside the bitmap: tile, clamp (repeat edges) or once (background)")
+	frames := flag.Int("frames", 1, "animate a sprite sheet of N vertically stacked frames")
+	fps := flag.Float64("fps", 8, "default frames per second for -frames")
+	channel := flag.String("channel", "both", "spatial output: both (ALBEDO and ALPHA), albedo, or alpha (foreground weight as a mask)")
+	respectModulate := flag.Bool("respect-modulate", false, "canvas_item: multiply the foreground colour by the node's modulate (incoming COLOR)")
+	bgGradient := flag.String("bg-gradient", "", "background: a vertical gradient TOP,BOTTOM (two colours) down the bitmap instead of -bg")
+	overTexture := flag.Bool("over-texture", false, "canvas_item: draw the bitmap over the node's texture, which shows through background pixels instead of -bg")
+	center := flag.Bool("center", false, "with -wrap once: draw the bitmap in the middle of the node's rect instead of the screen's top-left corner")
+	colorFormat := flag.String("colorformat", "float", "colour uniforms: float (vec4 0..1) or int (ivec4 of exact 0-255 bytes, Godot 4)")
+	filter := flag.String("filter", "nearest", "pixel sampling: nearest (pixel-perfect) or smooth (blend neighbours)")
+	godot := flag.Int("godot", 4, "target Godot major version: 3 or 4")
+	bitMeaning := flag.String("bitmeaning", "1=fg", "which bit value is drawn in the foreground colour: 1=fg or 0=fg")
+	bitOrder := flag.String("bitorder", "lsb", "pixel order within each XBM byte: lsb (standard) or msb")
+	material := flag.String("material", "", "also write a ShaderMaterial .tres using the shader")
+	emitHotspot := flag.Bool("emit-hotspot", false, "emit the XBM cursor hotspot as const ivec2 HOTSPOT")
+	tile := flag.String("tile", "", "repeat the bitmap X,Y times across the screen (or mesh) via a tile_repeat uniform")
+	var comments stringList
+	flag.Var(&comments, "comment", "add `text` as // lines at the top of the shader (repeatable; newlines start new lines)")
+	prefix := flag.String("prefix", "", "prepend this to DATA, WIDTH, xbm_bit and the other generated symbols (e.g. logo_), or auto for the XBM's own symbol prefix")
+	include := flag.Bool("include", false, "write a .gdshaderinc with only DATA and an xbm_bit_<name>() lookup named after the input")
+	cornerRadius := flag.Int("corner-radius", 0, "round the corners of each bitmap tile with this radius in pixels, drawing background (or discarding, with -discard-bg) outside")
+	noSourceColor := flag.Bool("no-source-color", false, "Godot 4: leave out the source_color hint on colour uniforms (raw floats, converted per -colorspace)")
+	emitBlink := flag.Bool("emit-blink", false, "add a blink_hz uniform that pulses the foreground's alpha that many times a second (0 = steady)")
+	emitRotation := flag.Bool("emit-rotation", false, "add a rotation uniform (radians) that turns the pattern around the tile centre")
+	fgName := flag.String("fgname", "fg_color", "identifier of the foreground colour uniform")
+	bgName := flag.String("bgname", "bg_color", "identifier of the background colour uniform")
+	invertName := flag.String("invertname", "invert", "identifier of the invert uniform")
+	widthDefine := flag.String("widthdefine", "", "exact #define holding the XBM width (default: any <name>_width)")
+	heightDefine := flag.String("heightdefine", "", "exact #define holding the XBM height (default: any <name>_height)")
+	format := flag.String("format", "", "input format: xbm, xpm, pbm, raster, ico or ascii (a text grid; default: detect by content)")
+	onChar := flag.String("onchar", "#", "ascii input: the character drawn as foreground")
+	array := flag.String("array", "", "XBM with several bits arrays (e.g. cursor and mask): the one to convert, by name (default: the first)")
+	maskPath := flag.String("mask", "", "bitmap of the same size whose clear bits make the image transparent, as X11 cursors ship with theirs")
+	unit := flag.String("unit", "", "XBM array element type: char or short (default: the declared type, else guess per value)")
+	threshold := flag.Int("threshold", 128, "PNG/GIF/JPEG input: pixels darker than this (1-255) become foreground")
+	flipX := flag.Bool("flipx", false, "mirror the bitmap left-right")
+	flipY := flag.Bool("flipy", false, "mirror the bitmap top-bottom")
+	rotate := flag.Int("rotate", 0, "turn the bitmap clockwise: 0, 90, 180 or 270 degrees")
+	downsample := flag.Int("downsample", 1, "shrink the bitmap by this factor, merging each NxN block into one pixel")
+	downsampleRule := flag.String("downsample-rule", "majority", "-downsample: a block is foreground if at least half its pixels are (majority) or any is (or)")
+	atlas := flag.Bool("atlas", false, "stack every -in (comma list or glob) into one shader, selected by a glyph_index uniform")
+	trim := flag.Bool("trim", false, "crop the bitmap to the bounding box of its foreground pixels")
+	invert := flag.Bool("invert", false, "bake an inverted bitmap into the output")
+	noInvertUniform := flag.Bool("no-invert-uniform", false, "omit the runtime invert uniform")
+	scene := flag.String("scene", "", "also write a .tscn scene showing the shader (or the -material)")
+	preview := flag.String("preview", "", "also render the bitmap with fg/bg to this PNG")
+	previewScale := flag.Int("preview-scale", 1, "pixel size of the -preview image")
+	checkPath := flag.String("check", "", "exit 1 if this shader's source checksum does not match -in and the options")
+	dry := flag.Bool("dry", false, "parse and report size without writing any files")
+	noClobber := flag.Bool("no-clobber", false, "refuse to overwrite existing output files (shader, texture, material, scene, preview)")
+	force := flag.Bool("force", false, "overwrite existing output files even with -no-clobber")
+	benchmark := flag.Bool("benchmark", false, "print the nanoseconds spent parsing, packing and building each input to stderr")
+	probe := flag.Bool("probe", false, "only inspect the input: print its format, size, element type and byte counts, and exit")
+	inDir := flag.String("indir", "", "convert every *.xbm under this directory (batch mode)")
+	outDir := flag.String("outdir", "", "output directory for batch mode or a glob -in")
+	strict := flag.Bool("strict", false, "batch or glob mode: stop at the first failing file")
+	jobs := flag.Int("jobs", runtime.NumCPU(), "batch or glob mode: convert this many files at once")
+	verbose := flag.Bool("verbose", false, "print notes on stderr about how the input is interpreted (e.g. row padding)")
+	quiet := flag.Bool("quiet", false, "print no success messages; errors and warnings still go to stderr")
+	jsonOut := flag.Bool("json", false, "report each conversion as a JSON object instead of the status line")
+	showColors := flag.Bool("show-colors", false, "print the fg/bg (and outline) colours as terminal swatches with their shader values; exits unless -in is given")
+	showVersion := flag.Bool("version", false, "print the version and exit")
 	flag.Parse()
 
-	if *in == "" {
-		fail("missing -in")
+	if *showVersion || flag.Arg(0) == "version" {
+		fmt.Println("xbm2gdshader", version)
+		return nil
+	}
+
+	order := xbm.LSBFirst
+	switch *bitOrder {
+	case "lsb":
+	case "msb":
+		order = xbm.MSBFirst
+	default:
+		return fmt.Errorf("unknown -bitorder %q (want lsb or msb)", *bitOrder)
+	}
+	zeroFG := false
+	switch *bitMeaning {
+	case "1=fg":
+	case "0=fg":
+		zeroFG = true
+	default:
+		return fmt.Errorf("unknown -bitmeaning %q (want 1=fg or 0=fg)", *bitMeaning)
+	}
+	if *mode == "ascii" {
+		return errors.New("-mode selects how the bitmap is stored; read text grids with -format ascii")
+	}
+	on := []rune(*onChar)
+	if len(on) != 1 {
+		return fmt.Errorf("-onchar must be a single character, got %q", *onChar)
+	}
+	if flagSet("onchar") && *format != "ascii" {
+		return errors.New("-onchar needs -format ascii")
+	}
+	var asciiOn rune // zero (the default '#') keeps source checksums
+	if on[0] != '#' {
+		asciiOn = on[0]
+	}
+	if *scale <= 0 {
+		return fmt.Errorf("-scale must be positive, got %d", *scale)
+	}
+	if *threshold < 1 || *threshold > 255 {
+		return fmt.Errorf("-threshold must be 1-255, got %d", *threshold)
+	}
+	if *frames <= 0 {
+		return fmt.Errorf("-frames must be positive, got %d", *frames)
+	}
+	fgEnv, err := envColor("XBM_FG", !flagSet("fg") && *fgIndex < 0, fg)
+	if err != nil {
+		return err
+	}
+	bgEnv, err := envColor("XBM_BG", !flagSet("bg") && *bgIndex < 0 && !*overTexture && *bgGradient == "", bg)
+	if err != nil {
+		return err
+	}
+	if *palette != "" || *fgIndex >= 0 || *bgIndex >= 0 {
+		if err := applyPalette(*palette, *fgIndex, *bgIndex, fg, bg); err != nil {
+			return err
+		}
+	}
+	// Map the defaults to the zero values, so existing source checksums
+	// still match
+	if *channel == "both" {
+		*channel = ""
+	}
+	if *colorFormat == "float" {
+		*colorFormat = ""
+	}
+	var gradient [2]string
+	if *bgGradient != "" {
+		parts := strings.Split(*bgGradient, ",")
+		if len(parts) != 2 {
+			return fmt.Errorf("-bg-gradient wants TOP,BOTTOM colours, got %q", *bgGradient)
+		}
+		for i, p := range parts {
+			gradient[i] = strings.TrimSpace(p)
+			if _, err := xbm.ParseColor(gradient[i]); err != nil {
+				return fmt.Errorf("-bg-gradient: %w", err)
+			}
+		}
+		if flagSet("bg") || *bgIndex >= 0 {
+			return errors.New("-bg-gradient replaces the flat background colour; drop -bg")
+		}
+	}
+	var tileRepeat [2]int
+	if *tile != "" {
+		var err error
+		if tileRepeat, err = parseTile(*tile); err != nil {
+			return err
+		}
+	}
+
+	conv := &converter{
+		opts: xbm.Options{
+			FG:              *fg,
+			BG:              *bg,
+			Mode:            *mode,
+			Pack:            *pack,
+			Chunk:           *chunk,
+			Annotate:        *annotate,
+			UniformDims:     *uniformDims,
+			UVSource:        *uvSource,
+			Scale:           *scale,
+			Godot:           *godot,
+			Wrap:            *wrap,
+			Center:          *center,
+			ColorSpace:      *colorSpace,
+			ColorFormat:     *colorFormat,
+			Filter:          *filter,
+			Channel:         *channel,
+			RespectModulate: *respectModulate,
+			OverTexture:     *overTexture,
+			BGGradient:      gradient,
+			Outline:         *outline,
+			DiscardBG:       *discardBG,
+			ZeroFG:          zeroFG,
+			Tile:            tileRepeat,
+			Frames:          *frames,
+			FPS:             *fps,
+			NoInvertUniform: *noInvertUniform,
+			EmitHotspot:     *emitHotspot,
+			EmitRotation:    *emitRotation,
+			EmitBlink:       *emitBlink,
+			NoSourceColor:   *noSourceColor,
+			PlainUniforms:   *material != "",
+			CornerRadius:    *cornerRadius,
+			FGName:          *fgName,
+			BGName:          *bgName,
+			InvertName:      *invertName,
+			Prefix:          *prefix,
+			Comment:         comments,
+		},
+		types:    strings.Split(*shType, ","),
+		bitOrder: order,
+		decode: xbm.DecodeOptions{
+			Threshold:    *threshold,
+			Unit:         *unit,
+			WidthDefine:  *widthDefine,
+			HeightDefine: *heightDefine,
+			Format:       *format,
+			Array:        *array,
+			OnChar:       asciiOn,
+		},
+		warnThreshold: flagSet("threshold"),
+		fgSet:         flagSet("fg") || *fgIndex >= 0 || fgEnv,
+		bgSet:         flagSet("bg") || *bgIndex >= 0 || bgEnv,
+		invert:        *invert,
+		goPkg:         *goPackage,
+		include:       *include,
+		trim:          *trim,
+		flipX:         *flipX,
+		flipY:         *flipY,
+		rotate:        *rotate,
+		downsample:    *downsample,
+		downOr:        *downsampleRule == "or",
+		atlas:         *atlas,
+		maxWords:      *maxWords,
+		dry:           *dry,
+		noClobber:     *noClobber && !*force,
+		benchmark:     *benchmark,
+		quiet:         *quiet,
+		verbose:       *verbose,
+		json:          *jsonOut,
+	}
+	switch *rotate {
+	case 0, 90, 180, 270:
+	default:
+		return fmt.Errorf("-rotate must be 0, 90, 180 or 270, got %d", *rotate)
+	}
+	if *downsample < 1 {
+		return fmt.Errorf("-downsample must be at least 1, got %d", *downsample)
+	}
+	if *downsampleRule != "majority" && *downsampleRule != "or" {
+		return fmt.Errorf("-downsample-rule must be \"majority\" or \"or\", got %q", *downsampleRule)
+	}
+	if *downsample > 1 && (*frames > 1 || *atlas) {
+		return errors.New("-downsample cannot be combined with -frames or -atlas")
+	}
+	if *rotate%180 != 0 && *frames > 1 {
+		return errors.New("-rotate 90/270 cannot be combined with -frames")
+	}
+	if *benchmark && *atlas {
+		return errors.New("-benchmark times one input at a time; it cannot be combined with -atlas")
+	}
+	if *atlas && (*frames > 1 || *trim) {
+		return errors.New("-atlas cannot be combined with -frames or -trim")
+	}
+	if *atlas && (!flagSet("out") || *inDir != "") {
+		return errors.New("-atlas needs -out and cannot be used with -indir")
+	}
+	if *maskPath != "" && (*inDir != "" || isGlob(*in) || *atlas || *trim || *preview != "" || *maxWords > 0 || *maskPath == "-") {
+		return errors.New("-mask needs a single -in and a mask file; it cannot be combined with -indir, -atlas, -trim, -preview or -maxwords")
+	}
+	if *trim && *frames > 1 {
+		return errors.New("-trim cannot be combined with -frames")
+	}
+	if *maxWords < 0 {
+		return fmt.Errorf("-maxwords must not be negative, got %d", *maxWords)
+	}
+	if *jobs < 1 {
+		return fmt.Errorf("-jobs must be at least 1, got %d", *jobs)
+	}
+	if *chunk > 0 && *maxWords > 0 {
+		return errors.New("-chunk cannot be combined with -maxwords, which may switch to texture mode")
+	}
+	if *annotate && *maxWords > 0 {
+		return errors.New("-annotate cannot be combined with -maxwords, which may switch to texture mode")
+	}
+	if *include && *maxWords > 0 {
+		return errors.New("-maxwords cannot be combined with -include, which needs array mode")
+	}
+	if *include && *prefix != "" {
+		return errors.New("-prefix cannot be combined with -include, which names the symbols itself")
+	}
+	if *include && (len(conv.types) > 1 || *material != "" || *scene != "") {
+		return errors.New("-include cannot be combined with several -type values, -material or -scene")
+	}
+	if *material != "" && len(conv.types) > 1 {
+		return errors.New("-material needs a single -type")
+	}
+	if *scene != "" && len(conv.types) > 1 {
+		return errors.New("-scene needs a single -type")
+	}
+	if flagSet("channel") && !slices.Contains(conv.types, "spatial") {
+		return errors.New("-channel needs -type spatial")
+	}
+	if *respectModulate && !slices.Contains(conv.types, "canvas_item") {
+		return errors.New("-respect-modulate needs -type canvas_item")
+	}
+	if *overTexture && !slices.Contains(conv.types, "canvas_item") {
+		return errors.New("-over-texture needs -type canvas_item")
+	}
+	if *overTexture && *bgGradient != "" {
+		return errors.New("-over-texture draws the node's texture instead of a background; drop -bg-gradient")
+	}
+	if *overTexture && (flagSet("bg") || *bgIndex >= 0) {
+		return errors.New("-over-texture draws the node's texture instead of a background colour; drop -bg")
+	}
+	if *mode == "gosource" {
+		switch {
+		case *include || *material != "" || *scene != "":
+			return errors.New("-mode gosource writes Go code; it cannot be combined with -include, -material or -scene")
+		case len(conv.types) > 1:
+			return errors.New("-mode gosource declares one Shader constant; give a single -type")
+		}
+	}
+	if flagSet("package") && *mode != "gosource" {
+		return errors.New("-package needs -mode gosource")
+	}
+	if (*mode == "raw" || *mode == "xbm") && (*include || *material != "" || *scene != "" || *preview != "" || *maskPath != "" || len(comments) > 0) {
+		return fmt.Errorf("-mode %s writes no shader; it cannot be combined with -include, -material, -scene, -preview, -mask or -comment", *mode)
+	}
+
+	if *showColors {
+		// Keep stdout clean when the shader goes there
+		w := os.Stdout
+		if *out == "-" {
+			w = os.Stderr
+		}
+		if err := printColors(w, conv.opts, conv.types[0]); err != nil {
+			return err
+		}
+		if *in == "" && *inDir == "" && !stdinIsPipe() {
+			return nil
+		}
 	}
 
-	src, err := os.ReadFile(*in)
-	check(err)
+	if *probe && (*inDir != "" || isGlob(*in)) {
+		return errors.New("-probe inspects a single -in")
+	}
+	if *inDir != "" {
+		if *outDir == "" {
+			return errors.New("-indir needs -outdir")
+		}
+		if *material != "" || *preview != "" || *scene != "" {
+			return errors.New("-material, -preview and -scene are not supported in batch mode")
+		}
+		if runBatch(conv, *inDir, *outDir, *strict, *jobs) != 0 {
+			return errReported
+		}
+		return nil
+	}
+	if isGlob(*in) && !*atlas {
+		if flagSet("out") {
+			return errors.New("-out cannot be used with a glob -in; use -outdir")
+		}
+		if *material != "" || *preview != "" || *scene != "" {
+			return errors.New("-material, -preview and -scene are not supported with a glob -in")
+		}
+		if runGlob(conv, *in, *outDir, *strict, *jobs) != 0 {
+			return errReported
+		}
+		return nil
+	}
 
-	w, h, raw, err := parseXBM(string(src))
-	check(err)
+	inPath := *in
+	if inPath == "" && stdinIsPipe() {
+		inPath = "-"
+	}
+	if inPath == "" {
+		return errors.New("missing -in")
+	}
+	if *out == "" {
+		*out = defaultOut(inPath, shaderExt(*godot, *include, *mode))
+	}
 
-	data32 := repackBitsToU32(raw, w, h)
+	if *maskPath != "" {
+		src, mask, err := conv.loadMask(*maskPath)
+		if err != nil {
+			return fmt.Errorf("-mask: %w", err)
+		}
+		conv.mask, conv.maskSum = &mask, src.sum.Sum("")
+	}
 
-	fgVec, err := hexToVec4(*fg)
-	check(err)
-	bgVec, err := hexToVec4(*bg)
-	check(err)
+	if *probe {
+		return probeInput(os.Stdout, inPath, conv.decode)
+	}
+	if *checkPath != "" {
+		if err := conv.checkStale(inPath, *checkPath); err != nil {
+			return err
+		}
+		if !*quiet {
+			fmt.Printf("%s is up to date\n", *checkPath)
+		}
+		return nil
+	}
 
-	sh := buildShader(*shType, w, h, data32, fgVec, bgVec)
+	if !*dry {
+		// Check the companion files before convert writes the shader
+		if err := conv.clobber(*material, *scene, *preview); err != nil {
+			return err
+		}
+	}
+	res, err := conv.convert(inPath, *out)
+	if err != nil {
+		return err
+	}
 
-	check(os.WriteFile(*out, []byte(sh), 0o644))
-	fmt.Printf("Wrote %s (%dx%d, %d uints)\n", *out, w, h, len(data32))
+	if *material != "" && !*dry {
+		opts := res.opts
+		opts.ShaderType = conv.types[0]
+		opts.Texture = res.texPath
+		if err := writeMaterial(*material, *out, opts); err != nil {
+			return err
+		}
+	}
+	if *scene != "" && !*dry {
+		opts := res.opts
+		opts.ShaderType = conv.types[0]
+		opts.Texture = res.texPath
+		ref := *out
+		if *material != "" {
+			ref = *material
+		}
+		if err := writeScene(*scene, ref, res.img, opts); err != nil {
+			return err
+		}
+	}
+	if *preview != "" && !*dry {
+		if err := writePreview(*preview, res.img, res.opts, *previewScale); err != nil {
+			return err
+		}
+	}
+
+	if *quiet {
+		return nil
+	}
+	// Keep stdout clean when it may be part of a pipeline.
+	msg := os.Stdout
+	if (inPath == "-" || *out == "-") && !*dry {
+		msg = os.Stderr
+	}
+	fmt.Fprintln(msg, conv.report(res))
+	return nil
 }
 
-func check(err error) {
+// probeInput prints the structure xbm.Probe finds in the input at inPath.
+// A file that would not convert is still described as far as it parsed,
+// and its error is returned afterwards.
+func probeInput(w io.Writer, inPath string, decode xbm.DecodeOptions) error {
+	src, err := readInput(inPath)
 	if err != nil {
-		fail(err.Error())
+		return err
+	}
+	info, perr := xbm.Probe(src, decode)
+	fmt.Fprintf(w, "%s: %s\n", displayInput(inPath), info.Format)
+	if info.Array != "" {
+		fmt.Fprintf(w, "  array:  %s_bits", info.Array)
+		if names := xbm.ArrayNames(src); len(names) > 1 {
+			fmt.Fprintf(w, " (of %s)", strings.Join(names, ", "))
+		}
+		fmt.Fprintln(w)
+	}
+	if info.Width > 0 || info.Height > 0 {
+		fmt.Fprintf(w, "  size:   %dx%d\n", info.Width, info.Height)
+	}
+	if info.Unit != "" {
+		fmt.Fprintf(w, "  unit:   %s (from %s)\n", info.Unit, info.UnitFrom)
+		fmt.Fprintf(w, "  values: %d\n", info.Values)
+	}
+	if info.Want > 0 || info.Bytes > 0 {
+		match := "matches"
+		switch {
+		case info.Bytes < info.Want:
+			match = fmt.Sprintf("%d short", info.Want-info.Bytes)
+		case info.Bytes > info.Want && perr == nil:
+			match = fmt.Sprintf("%d over, all zero padding", info.Bytes-info.Want)
+		case info.Bytes > info.Want:
+			match = fmt.Sprintf("%d over", info.Bytes-info.Want)
+		}
+		fmt.Fprintf(w, "  bytes:  %d, want %d (%s)\n", info.Bytes, info.Want, match)
+	}
+	return perr
+}
+
+// printColors writes a truecolor ANSI swatch of each colour in opts,
+// followed by its hex value and the uniform default the shader of type
+// shType would get. Transparency is not shown in the swatch, only in the
+// values.
+func printColors(w io.Writer, opts xbm.Options, shType string) error {
+	opts.ShaderType = shType
+	colors := []struct{ name, value string }{
+		{"fg", opts.FG},
+		{"bg", opts.BG},
+	}
+	if opts.BGGradient != [2]string{} {
+		colors[1] = struct{ name, value string }{"bg-top", opts.BGGradient[0]}
+		colors = append(colors, struct{ name, value string }{"bg-bottom", opts.BGGradient[1]})
+	}
+	if opts.Outline != "" {
+		colors = append(colors, struct{ name, value string }{"outline", opts.Outline})
+	}
+	for _, col := range colors {
+		if col.value == "" {
+			continue // BuildShader's default
+		}
+		c, err := xbm.ParseColor(col.value)
+		if err != nil {
+			return fmt.Errorf("-%s: %w", col.name, err)
+		}
+		fmt.Fprintf(w, "\x1b[48;2;%d;%d;%dm      \x1b[0m %-9s %s  %s\n", c.R, c.G, c.B, col.name, c, opts.ColorValue(c))
+	}
+	return nil
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ", ") }
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// converter holds the settings shared by every conversion in a run.
+type converter struct {
+	opts     xbm.Options
+	types    []string // shader types to generate; opts.ShaderType is ignored
+	bitOrder xbm.BitOrder
+	decode   xbm.DecodeOptions
+	invert   bool   // bake inversion into the bitmap
+	goPkg    string // -mode gosource package; empty names it after the output directory
+
+	mask    *xbm.Image // -mask bitmap; nil without one
+	maskSum string     // checksum of the -mask file
+
+	warnThreshold bool // -threshold was given; warn if the input is 1-bit
+	fgSet, bgSet  bool // -fg/-bg were given and override comment hints
+	include       bool // write shader includes named after each input
+	trim          bool // crop to the foreground's bounding box
+	flipX, flipY  bool // mirror the bitmap
+	rotate        int  // clockwise degrees, applied after flipping
+	downsample    int  // block size to shrink by after rotating; 1 keeps the size
+	downOr        bool // a -downsample block is set if any pixel is, not half
+	maxWords      int  // switch array mode to texture above this many words
+	atlas         bool // stack every -in into one glyph_index atlas
+	atlasHeight   int  // height of one atlas glyph, set by loadAtlas
+	dry           bool // parse and build, but write nothing
+	noClobber     bool // fail rather than overwrite an existing output file
+	benchmark     bool // time the parse, pack and build phases on stderr
+	quiet         bool // no success messages
+	verbose       bool // explain how the input was interpreted
+	json          bool // report conversions as JSON objects
+}
+
+// result describes one finished conversion.
+type result struct {
+	in      string
+	img     xbm.Image
+	opts    xbm.Options // c.opts with the input's colour hints applied
+	outs    []string    // shader paths, one per type
+	texPath string
+	dry     bool
+}
+
+// coverage is the foreground share of the bitmap, honouring -bitmeaning.
+func (r result) coverage() float64 {
+	if r.opts.ZeroFG {
+		return 1 - r.img.Coverage()
 	}
+	return r.img.Coverage()
 }
-func fail(msg string) {
-	fmt.Fprintln(os.Stderr, "error:", msg)
-	os.Exit(1)
+
+// words is the length of the DATA array: packed run boundaries in rle
+// mode, otherwise one uint per 32 pixels.
+func (r result) words() int {
+	if r.opts.Mode == "rle" {
+		return len(xbm.PackRuns(r.img.Runs(), r.img.Width, r.img.Height))
+	}
+	return len(r.img.Pack())
 }
 
-func parseXBM(s string) (int, int, []byte, error) {
-	wm := reW.FindStringSubmatch(s)
-	hm := reH.FindStringSubmatch(s)
-	am := reArr.FindStringSubmatch(s)
-	if wm == nil || hm == nil || am == nil {
-		return 0, 0, nil, errors.New("failed to parse #defines or bits array")
+// report is the line printed for a finished conversion: the summary, or
+// a JSON object with -json.
+func (c *converter) report(r result) string {
+	if !c.json {
+		return r.summary(c.opts.Godot)
 	}
-	w, _ := strconv.Atoi(wm[1])
-	h, _ := strconv.Atoi(hm[1])
+	stats := struct {
+		Input    string   `json:"input"`
+		Outputs  []string `json:"outputs"`
+		Texture  string   `json:"texture,omitempty"`
+		Width    int      `json:"width"`
+		Height   int      `json:"height"`
+		Words    int      `json:"words"`
+		Bytes    int      `json:"bytes"`
+		Coverage float64  `json:"coverage"`
+		Dry      bool     `json:"dry,omitempty"`
+	}{
+		Input:    displayInput(r.in),
+		Texture:  r.texPath,
+		Width:    r.img.Width,
+		Height:   r.img.Height,
+		Words:    r.words(),
+		Bytes:    len(r.img.Bits),
+		Coverage: math.Round(1000*r.coverage()) / 10,
+		Dry:      r.dry,
+	}
+	for _, o := range r.outs {
+		stats.Outputs = append(stats.Outputs, displayPath(o))
+	}
+	b, _ := json.Marshal(stats) // plain data; cannot fail
+	return string(b)
+}
 
-	nums := reNum.FindAllString(am[1], -1)
-	if len(nums) == 0 {
-		return 0, 0, nil, errors.New("no numbers found in bits array")
+func (r result) summary(godot int) string {
+	outs := make([]string, len(r.outs))
+	for i, o := range r.outs {
+		outs[i] = displayPath(o)
+	}
+	detail := fmt.Sprintf("%d uints", len(r.img.Pack()))
+	if r.opts.Mode == "rle" {
+		detail = fmt.Sprintf("%d rle words vs %d uints in array mode", r.words(), len(r.img.Pack()))
+	}
+	if r.texPath != "" {
+		detail = "texture " + r.texPath
+	}
+	detail += fmt.Sprintf(", %.1f%% foreground", 100*r.coverage())
+	if godot == 3 {
+		detail += ", Godot 3"
 	}
+	verb := "Wrote"
+	if r.dry {
+		verb = "Would write"
+	}
+	return fmt.Sprintf("%s %s (%dx%d, %s)", verb, strings.Join(outs, ", "), r.img.Width, r.img.Height, detail)
+}
 
-	// Build raw byte stream; if value > 0xFF, assume 16-bit little-endian (common for short-based XBM).
-	out := make([]byte, 0, len(nums))
-	for _, t := range nums {
-		var v int64
-		var err error
-		if strings.HasPrefix(t, "0x") || strings.HasPrefix(t, "0X") {
-			v, err = strconv.ParseInt(t[2:], 16, 64)
+// convert reads one XBM from inPath and writes its shader (plus any
+// companion files) to outPath. Either path may be "-". With several shader
+// types, each output gets a type suffix (see typedPath).
+func (c *converter) convert(inPath, outPath string) (result, error) {
+	load := c.load
+	if c.atlas {
+		load = c.loadAtlas
+	}
+	src, img, err := load(inPath)
+	if err != nil {
+		return result{}, err
+	}
+	var header []string
+	if c.trim {
+		w, h := img.Width, img.Height
+		var x0, y0 int
+		if c.opts.ZeroFG {
+			// Crop to the clear (foreground) bits
+			img, x0, y0 = xbm.Trim(xbm.Invert(img))
+			img = xbm.Invert(img)
 		} else {
-			v, err = strconv.ParseInt(t, 10, 64)
+			img, x0, y0 = xbm.Trim(img)
+		}
+		header = append(header, fmt.Sprintf("trimmed from %dx%d at offset (%d, %d)", w, h, x0, y0))
+	}
+
+	res := result{in: inPath, img: img, opts: c.opts, dry: c.dry}
+	if c.atlas {
+		res.opts.Atlas = true
+		res.opts.Frames = img.Height / c.atlasHeight
+	}
+	res.opts.Mask = c.mask
+	res.opts.Checksum = c.checksum(src.sum)
+	res.opts.Header = append(res.opts.Header, header...)
+	if c.include {
+		res.opts.Include = includeName(inPath, outPath)
+	}
+	if res.opts.Prefix == "auto" {
+		res.opts.Prefix = c.symbolPrefix(src, inPath, outPath)
+	}
+	fg, bg := xbm.ColorHints(src.text)
+	if fg != "" && !c.fgSet {
+		res.opts.FG = fg
+	}
+	if bg != "" && !c.bgSet {
+		res.opts.BG = bg
+	}
+
+	var bench benchTimes
+	if c.benchmark {
+		// Parse the source again on its own, without reading the file or
+		// the bitmap options that load also does
+		start := time.Now()
+		xbm.DecodeWith(src.text, c.decode)
+		bench.parse = time.Since(start)
+		start = time.Now()
+		img.Pack()
+		bench.pack = time.Since(start)
+	}
+
+	if words := len(img.Pack()); c.maxWords > 0 && words > c.maxWords && (res.opts.Mode == "" || res.opts.Mode == "array") {
+		res.opts.Mode = "texture"
+		if !c.quiet {
+			fmt.Fprintf(os.Stderr, "note: %s: %d words exceed -maxwords %d; using texture mode\n", displayInput(inPath), words, c.maxWords)
+		}
+	}
+	if res.opts.Mode == "rle" && res.words() >= len(img.Pack()) {
+		fmt.Fprintf(os.Stderr, "warning: %s: rle mode needs %d words, array mode only %d\n",
+			displayInput(inPath), res.words(), len(img.Pack()))
+	}
+
+	if c.opts.Mode == "raw" || c.opts.Mode == "xbm" {
+		var raw bytes.Buffer
+		start := time.Now()
+		if c.opts.Mode == "xbm" {
+			if inPath != "-" && outPath != "-" && filepath.Clean(inPath) == filepath.Clean(outPath) {
+				return result{}, fmt.Errorf("-mode xbm would overwrite %s; give another -out", inPath)
+			}
+			err = xbm.WriteXBM(&raw, img, includeName(inPath, outPath))
+		} else {
+			err = xbm.WriteRaw(&raw, img)
 		}
 		if err != nil {
-			return 0, 0, nil, fmt.Errorf("bad number %q: %w", t, err)
+			return result{}, err
+		}
+		bench.build = time.Since(start)
+		if c.benchmark {
+			printBenchmark(inPath, bench)
+		}
+		res.outs = []string{outPath}
+		if !c.dry {
+			if err := c.clobber(outPath); err != nil {
+				return result{}, err
+			}
+			if err := writeOutput(outPath, raw.Bytes()); err != nil {
+				return result{}, err
+			}
+		}
+		return res, nil
+	}
+
+	shaders := make([]string, len(c.types))
+	for i, t := range c.types {
+		opts := res.opts
+		opts.ShaderType = t
+		if opts.Mode == "gosource" {
+			opts.Mode = "" // the constant holds an array-mode shader
+		}
+		start := time.Now()
+		if shaders[i], err = xbm.BuildShader(img, opts); err != nil {
+			return result{}, err
+		}
+		bench.build += time.Since(start)
+		if res.opts.Mode == "gosource" {
+			pkg := c.goPkg
+			if pkg == "" {
+				pkg = dirPackage(outPath)
+			}
+			if shaders[i], err = xbm.GoSource(pkg, shaders[i]); err != nil {
+				return result{}, fmt.Errorf("%w; name one with -package", err)
+			}
+		}
+		path := outPath
+		if len(c.types) > 1 {
+			if outPath == "-" {
+				return result{}, errors.New("several -type values need a file -out")
+			}
+			path = typedPath(outPath, t)
+		}
+		res.outs = append(res.outs, path)
+	}
+	if c.benchmark {
+		printBenchmark(inPath, bench)
+	}
+
+	if res.opts.Mode == "texture" || res.opts.Mode == "itexture" || res.opts.Mode == "sdf" {
+		if outPath == "-" {
+			return result{}, errors.New("texture mode needs a file -out to place the .png next to")
+		}
+		res.texPath = strings.TrimSuffix(outPath, filepath.Ext(outPath)) + ".png"
+	}
+	if c.dry {
+		return res, nil
+	}
+	if err := c.clobber(append(res.outs, res.texPath)...); err != nil {
+		return result{}, err
+	}
+
+	if res.texPath != "" {
+		var png bytes.Buffer
+		write := xbm.WritePNG
+		switch res.opts.Mode {
+		case "itexture":
+			write = xbm.WritePackedPNG
+		case "sdf":
+			tile := res.opts.Wrap == "" || res.opts.Wrap == "tile"
+			write = func(w io.Writer, img xbm.Image) error { return xbm.WriteSDFPNG(w, img, tile) }
+		}
+		if err := write(&png, img); err != nil {
+			return result{}, err
+		}
+		if err := os.WriteFile(res.texPath, png.Bytes(), 0o644); err != nil {
+			return result{}, err
+		}
+		// Leave an existing .import alone: Godot has added the texture's
+		// uid to it, and replacing that would break references to it.
+		imp := res.texPath + ".import"
+		if _, err := os.Stat(imp); errors.Is(err, fs.ErrNotExist) {
+			if err := os.WriteFile(imp, []byte(xbm.TextureImport(c.opts.Godot)), 0o644); err != nil {
+				return result{}, err
+			}
+		}
+	}
+
+	for i, path := range res.outs {
+		if err := writeOutput(path, []byte(shaders[i])); err != nil {
+			return result{}, err
+		}
+	}
+	return res, nil
+}
+
+// clobber returns an error for the first of paths that already exists
+// when -no-clobber is in effect. Empty paths and "-" are skipped. It is
+// called before anything is written, so a refused run leaves every file
+// as it was.
+func (c *converter) clobber(paths ...string) error {
+	if !c.noClobber {
+		return nil
+	}
+	for _, p := range paths {
+		if p == "" || p == "-" {
+			continue
+		}
+		if _, err := os.Lstat(p); err == nil {
+			return fmt.Errorf("%s already exists; not overwriting it with -no-clobber (use -force)", p)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// symbolPrefix is the -prefix auto prefix for src: the name the XBM gives
+// its bits array and #defines (logo_bits → "logo_"), the array chosen by
+// -array if there are several. Other formats have no symbols, so the
+// input's file name stands in, as for -include.
+func (c *converter) symbolPrefix(src source, inPath, outPath string) string {
+	if src.format == "xbm" {
+		if c.decode.Array != "" {
+			return strings.TrimSuffix(c.decode.Array, "_bits") + "_"
+		}
+		if names := xbm.ArrayNames(src.text); len(names) > 0 {
+			return names[0] + "_"
+		}
+	}
+	return includeName(inPath, outPath) + "_"
+}
+
+// benchTimes are the phase durations -benchmark reports for one input.
+type benchTimes struct {
+	parse time.Duration // decoding the source (for XBM, the text scan)
+	pack  time.Duration // repacking the bitmap into 32-bit words
+	build time.Duration // generating the output, every -type together
+}
+
+// printBenchmark writes t to stderr, out of the way of -out -.
+func printBenchmark(inPath string, t benchTimes) {
+	fmt.Fprintf(os.Stderr, "benchmark: %s: parse %d ns, pack %d ns, build %d ns\n",
+		displayInput(inPath), t.parse.Nanoseconds(), t.pack.Nanoseconds(), t.build.Nanoseconds())
+}
+
+// checksum identifies the input summed in d converted with c's settings,
+// finishing d. The shader type is left out, so every output of a
+// multi-type run carries the same sum.
+func (c *converter) checksum(d *xbm.Digest) string {
+	// Colours are hashed in one spelling, so "blue" and "#0000FF" agree
+	opts := c.opts
+	for _, s := range []*string{&opts.FG, &opts.BG, &opts.BGGradient[0], &opts.BGGradient[1], &opts.Outline} {
+		if col, err := xbm.ParseColor(*s); *s != "" && err == nil {
+			*s = col.String()
+		}
+	}
+	settings := nonZeroFields(opts) + nonZeroFields(c.decode) +
+		fmt.Sprintf("order=%d invert=%t", c.bitOrder, c.invert)
+	for _, f := range []struct {
+		on   bool
+		name string
+	}{{c.flipX, "flipx"}, {c.flipY, "flipy"}, {c.trim, "trim"}, {c.atlas, "atlas"}} {
+		if f.on {
+			settings += " " + f.name
+		}
+	}
+	if c.rotate != 0 {
+		settings += fmt.Sprintf(" rotate=%d", c.rotate)
+	}
+	if c.downsample > 1 {
+		settings += fmt.Sprintf(" downsample=%d or=%t", c.downsample, c.downOr)
+	}
+	if c.mask != nil {
+		settings += " mask=" + c.maskSum
+	}
+	if c.maxWords != 0 {
+		settings += fmt.Sprintf(" maxwords=%d", c.maxWords)
+	}
+	return d.Sum(settings)
+}
+
+// nonZeroFields formats the set fields of struct v as "Name=value ". Zero
+// fields are skipped so that options added later, left unset, do not change
+// the checksum of existing shaders.
+func nonZeroFields(v any) string {
+	var b strings.Builder
+	rv := reflect.ValueOf(v)
+	for i := 0; i < rv.NumField(); i++ {
+		if f := rv.Field(i); !f.IsZero() {
+			fmt.Fprintf(&b, "%s=%v ", rv.Type().Field(i).Name, f.Interface())
+		}
+	}
+	return b.String()
+}
+
+// checkStale compares the checksum embedded in the shader at shaderPath
+// with the one inPath would produce now.
+func (c *converter) checkStale(inPath, shaderPath string) error {
+	r, err := openInput(inPath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	sum := xbm.NewDigest()
+	if _, err := io.Copy(sum, r); err != nil {
+		return err
+	}
+	shader, err := os.ReadFile(shaderPath)
+	if err != nil {
+		return err
+	}
+	got, ok := xbm.EmbeddedChecksum(shader)
+	if !ok {
+		return fmt.Errorf("%s has no source checksum", shaderPath)
+	}
+	if want := c.checksum(sum); got != want {
+		return fmt.Errorf("%s is stale: built from %s, %s now gives %s", shaderPath, got, displayInput(inPath), want)
+	}
+	return nil
+}
+
+// shaderExt returns the output file extension for the target Godot
+// version, the include extension in include mode, or that of the file
+// another -mode writes instead of a shader.
+func shaderExt(godot int, include bool, mode string) string {
+	switch {
+	case mode == "raw":
+		return ".bin"
+	case mode == "xbm":
+		return ".xbm"
+	case mode == "visualshader":
+		return ".tres"
+	case mode == "gosource":
+		return ".go"
+	case include:
+		return ".gdshaderinc"
+	case godot == 3:
+		return ".shader" // Godot 3 shader resources use .shader
+	}
+	return ".gdshader"
+}
+
+// dirPackage is the default Go package name for a -mode gosource file at
+// outPath: the name of the directory it is written to (the current one for
+// stdout), which GoSource rejects if it is not an identifier.
+func dirPackage(outPath string) string {
+	dir := "."
+	if outPath != "-" {
+		dir = filepath.Dir(outPath)
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(filepath.Base(abs))
+}
+
+// defaultOut derives the shader path from the input path: foo.xbm (or
+// foo.xbm.gz) becomes foo.gdshader (see shaderExt) beside it, and stdin
+// becomes out.gdshader in the current directory.
+func defaultOut(inPath, ext string) string {
+	if inPath == "-" {
+		return "out" + ext
+	}
+	return trimExt(inPath) + ext
+}
+
+// includeName derives the include-mode symbol suffix from the input file
+// name (or the output's for stdin): icons/Logo-2.xbm gives "logo_2".
+func includeName(inPath, outPath string) string {
+	path := inPath
+	if path == "-" {
+		path = outPath
+	}
+	if path == "-" {
+		return "bitmap"
+	}
+	base := trimExt(filepath.Base(path))
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return '_'
+	}, base)
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// format is the input format of src: -format if given, otherwise the one
+// detected from its content.
+func (c *converter) format(src []byte) string {
+	if c.decode.Format != "" {
+		return c.decode.Format
+	}
+	return xbm.Format(src)
+}
+
+// source is what a conversion keeps of its input besides the image.
+type source struct {
+	format string      // the input format (see converter.format)
+	text   []byte      // the input, or the xbm.Skeleton of a streamed XBM
+	sum    *xbm.Digest // every byte of the input, for the checksum
+}
+
+// sniffSize is how much of an input read peeks at to tell its format.
+const sniffSize = 4096
+
+// read decodes the input at inPath, adding its bytes to src.sum and
+// setting src.text and src.format. An XBM is decoded as it streams in and
+// only its skeleton is kept, so a huge bitmap never has its text in
+// memory; other formats, and XBM under -benchmark (which parses again),
+// are read whole first.
+func (c *converter) read(src *source, inPath string) (xbm.Image, error) {
+	r, err := openInput(inPath)
+	if err != nil {
+		return xbm.Image{}, err
+	}
+	defer r.Close()
+	br := bufio.NewReaderSize(r, sniffSize)
+	head, _ := br.Peek(sniffSize) // a read error resurfaces below
+	if c.format(head) == "xbm" && bytes.Contains(head, []byte("#define")) && !c.benchmark {
+		var skel xbm.Skeleton
+		in := io.TeeReader(br, io.MultiWriter(src.sum, &skel))
+		img, err := xbm.ParseReader(in, c.decode)
+		if err == nil {
+			// The checksum covers anything after the array too
+			_, err = io.Copy(io.Discard, in)
 		}
-		if v < 0 {
-			v = 0
+		src.format, src.text = "xbm", skel.Bytes()
+		return img, err
+	}
+
+	all, err := io.ReadAll(io.TeeReader(br, src.sum))
+	if err != nil {
+		return xbm.Image{}, err
+	}
+	src.format, src.text = c.format(all), all
+	return xbm.DecodeWith(all, c.decode)
+}
+
+// load reads one input and decodes it, applying the per-image settings:
+// bit order, inversion, flips and rotation.
+func (c *converter) load(inPath string) (source, xbm.Image, error) {
+	src := source{sum: xbm.NewDigest()}
+	img, err := c.loadInto(&src, inPath)
+	return src, img, err
+}
+
+// loadInto is load adding the input to src, which may hold others before
+// it (see loadAtlas).
+func (c *converter) loadInto(src *source, inPath string) (xbm.Image, error) {
+	img, err := c.read(src, inPath)
+	if err != nil {
+		return xbm.Image{}, err
+	}
+
+	if c.warnThreshold && src.format != "raster" {
+		fmt.Fprintf(os.Stderr, "warning: %s: -threshold has no effect on 1-bit %s input\n", displayInput(inPath), src.format)
+	}
+	if c.decode.Array == "" && !c.quiet && src.format == "xbm" {
+		if names := xbm.ArrayNames(src.text); len(names) > 1 {
+			fmt.Fprintf(os.Stderr, "note: %s: %d bits arrays (%s); converting %s (choose with -array)\n",
+				displayInput(inPath), len(names), strings.Join(names, ", "), names[0])
 		}
-		if v <= 0xFF {
-			out = append(out, byte(v))
+	}
+	if c.bitOrder != xbm.LSBFirst {
+		// Only XBM bytes are ambiguous; the other formats define their order
+		if src.format == "xbm" {
+			img.BitOrder = c.bitOrder
 		} else {
-			out = append(out, byte(v&0xFF), byte((v>>8)&0xFF))
+			fmt.Fprintf(os.Stderr, "warning: %s: -bitorder has no effect on %s input\n", displayInput(inPath), src.format)
+		}
+	}
+	if c.verbose && src.format == "xbm" && img.Width%8 != 0 {
+		// One write, so notes from parallel conversions do not interleave
+		note := fmt.Sprintf("note: %s: width %d is not a multiple of 8, so the last %d bits of each row are byte padding and never drawn",
+			displayInput(inPath), img.Width, 8-img.Width%8)
+		if n := img.PaddingRows(); n > 0 {
+			note += fmt.Sprintf("; %d of %d rows set some (check the width #define)", n, img.Height)
+		}
+		fmt.Fprintln(os.Stderr, note)
+	}
+	if c.invert {
+		img = xbm.Invert(img)
+	}
+	if c.flipX || c.flipY {
+		img = xbm.Flip(img, c.flipX, c.flipY)
+	}
+	if c.rotate != 0 {
+		if img, err = xbm.Rotate(img, c.rotate); err != nil {
+			return xbm.Image{}, err
+		}
+	}
+	if c.downsample > 1 {
+		if img, err = xbm.Downsample(img, c.downsample, c.downOr); err != nil {
+			return xbm.Image{}, err
 		}
 	}
-	return w, h, out, nil
+	return img, nil
 }
 
-// XBM rows are byte-padded, LSB-first within each byte.
-// Repack to tight bitstream (width*height bits) → 32-bit words for the shader.
-func repackBitsToU32(xbm []byte, w, h int) []uint32 {
-	rowBytes := (w + 7) / 8
-	totalBits := w * h
-	words := (totalBits + 31) / 32
-	dst := make([]uint32, words)
+// loadMask reads the -mask bitmap at path like load, with the same flips,
+// rotation and downsampling so it stays aligned with the image, but never
+// inverted: a mask bit always means "drawn". A mask file with several bits
+// arrays gives its first.
+func (c *converter) loadMask(path string) (source, xbm.Image, error) {
+	m := *c
+	m.invert = false
+	m.decode.Array = ""
+	m.warnThreshold, m.quiet = false, true
+	return m.load(path)
+}
 
-	setBit := func(i int) {
-		dst[i>>5] |= 1 << uint(i&31)
+// loadAtlas loads every input named by list, a comma-separated list of
+// paths or glob patterns (matches in name order), and stacks them into
+// one atlas image. The returned source is all inputs joined, for the
+// checksum and colour hints.
+func (c *converter) loadAtlas(list string) (source, xbm.Image, error) {
+	var paths []string
+	for _, p := range strings.Split(list, ",") {
+		if !isGlob(p) {
+			paths = append(paths, p)
+			continue
+		}
+		matches, err := filepath.Glob(p)
+		if err != nil {
+			return source{}, xbm.Image{}, err
+		}
+		if len(matches) == 0 {
+			return source{}, xbm.Image{}, fmt.Errorf("no files match %s", p)
+		}
+		paths = append(paths, matches...)
 	}
 
-	outIdx := 0
-	for y := 0; y < h; y++ {
-		base := y * rowBytes
-		for x := 0; x < w; x++ {
-			bi := base + (x >> 3)
-			if bi >= len(xbm) {
-				break
-			}
-			bit := (xbm[bi] >> uint(x&7)) & 1 // LSB is leftmost pixel
-			if bit == 1 {
-				setBit(outIdx)
-			}
-			outIdx++
+	all := source{sum: xbm.NewDigest()}
+	imgs := make([]xbm.Image, len(paths))
+	for i, p := range paths {
+		src := source{sum: all.sum}
+		img, err := c.loadInto(&src, p)
+		if err != nil {
+			return source{}, xbm.Image{}, fmt.Errorf("%s: %w", displayInput(p), err)
+		}
+		all.sum.Write([]byte{0})
+		all.text = append(append(all.text, src.text...), 0)
+		if i == 0 {
+			all.format = src.format
 		}
+		imgs[i] = img
 	}
-	return dst
+	atlas, err := xbm.Stack(imgs...)
+	if err != nil {
+		return source{}, xbm.Image{}, err
+	}
+	c.atlasHeight = imgs[0].Height
+	return all, atlas, nil
+}
+
+// typedPath inserts a shader type suffix before the extension of path,
+// e.g. icon.gdshader → icon_canvas.gdshader.
+func typedPath(path, shaderType string) string {
+	suffix := "_" + shaderType
+	if shaderType == "canvas_item" {
+		suffix = "_canvas"
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + suffix + ext
+}
+
+// writeMaterial writes a .tres ShaderMaterial at path referencing the
+// shader at shaderPath and any opts.Texture, relative to the material's
+// directory.
+func writeMaterial(path, shaderPath string, opts xbm.Options) error {
+	if shaderPath == "-" {
+		return errors.New("-material needs a file -out for the material to reference")
+	}
+	ref, err := relPath(filepath.Dir(path), shaderPath)
+	if err != nil {
+		return err
+	}
+	if opts.Texture, err = relTexture(path, opts.Texture); err != nil {
+		return err
+	}
+	tres, err := xbm.BuildMaterial(ref, opts)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(tres), 0o644)
 }
 
-func hexToVec4(hex string) (string, error) {
-	s := strings.TrimPrefix(hex, "#")
-	if len(s) != 8 {
-		return "", fmt.Errorf("want #RRGGBBAA, got %q", hex)
+// writeScene writes a .tscn at path showing the shader or material at
+// ref (and any opts.Texture), relative to the scene's directory.
+func writeScene(path, ref string, img xbm.Image, opts xbm.Options) error {
+	if ref == "-" {
+		return errors.New("-scene needs a file -out for the scene to reference")
+	}
+	rel, err := relPath(filepath.Dir(path), ref)
+	if err != nil {
+		return err
+	}
+	if opts.Texture, err = relTexture(path, opts.Texture); err != nil {
+		return err
+	}
+	tscn, err := xbm.BuildScene(rel, img, opts)
+	if err != nil {
+		return err
 	}
-	r, _ := strconv.ParseUint(s[0:2], 16, 8)
-	g, _ := strconv.ParseUint(s[2:4], 16, 8)
-	b, _ := strconv.ParseUint(s[4:6], 16, 8)
-	a, _ := strconv.ParseUint(s[6:8], 16, 8)
-	return fmt.Sprintf("vec4(%g,%g,%g,%g)",
-		float32(r)/255, float32(g)/255, float32(b)/255, float32(a)/255), nil
+	return os.WriteFile(path, []byte(tscn), 0o644)
 }
 
-func buildShader(shaderType string, w, h int, data []uint32, fg, bg string) string {
+// writePreview renders img with the foreground/background colours of opts
+// (honouring ZeroFG) to a PNG at path.
+func writePreview(path string, img xbm.Image, opts xbm.Options, scale int) error {
+	if scale <= 0 {
+		return fmt.Errorf("-preview-scale must be positive, got %d", scale)
+	}
+	fg, err := xbm.ParseColor(opts.FG)
+	if err != nil {
+		return err
+	}
+	bg, err := xbm.ParseColor(opts.BG)
+	if err != nil {
+		return err
+	}
+	if opts.ZeroFG {
+		fg, bg = bg, fg // set bits are drawn in the background colour
+	}
 	var buf bytes.Buffer
-	fmt.Fprintf(&buf, "shader_type %s;\n\n", shaderType)
-
-	// Constants
-	fmt.Fprintf(&buf, "const uint WIDTH = %du;\n", w)
-	fmt.Fprintf(&buf, "const uint HEIGHT = %du;\n", h)
-	fmt.Fprintf(&buf, "const uint WORDS = %du;\n\n", len(data))
-
-	// Uniforms
-	buf.WriteString("// Foreground = bit 1 (XBM 'black'); Background = bit 0\n")
-	fmt.Fprintf(&buf, "instance uniform vec4 fg_color = %s;\n", fg)
-	fmt.Fprintf(&buf, "instance uniform vec4 bg_color = %s;\n", bg)
-	buf.WriteString("instance uniform bool invert = false;\n")
-	// buf.WriteString("uniform ivec2 tile_repeat = ivec2(8, 6); // (unused in pixel-perfect mode)\n")
-	buf.WriteString("\n")
-
-	// Data array
-	buf.WriteString("const uint DATA[WORDS] = uint[](\n")
-	for i, v := range data {
-		sep := ","
-		if i == len(data)-1 {
-			sep = ""
-		}
-		fmt.Fprintf(&buf, "    0x%08Xu%s\n", v, sep)
-	}
-	buf.WriteString(");\n\n")
-
-	// Bit lookup
-	buf.WriteString(`bool xbm_bit(ivec2 p) {
-    if (p.x < 0 || p.y < 0 || p.x >= int(WIDTH) || p.y >= int(HEIGHT)) return false;
-    int idx = p.y * int(WIDTH) + p.x;
-    uint w = DATA[idx >> 5];
-    return ((w >> uint(idx & 31)) & 1u) == 1u;
-}
-` + "\n")
-
-	// Pixel-perfect tiling fragment (screen-locked)
-	if shaderType == "canvas_item" {
-		buf.WriteString(`void fragment() {
-    // Convert normalized screen UV (0..1) into integer screen pixel coords
-    vec2 screen_px = floor(SCREEN_UV / SCREEN_PIXEL_SIZE);
-
-    // Tile every WIDTH × HEIGHT screen pixels
-    int px = int(mod(screen_px.x, float(WIDTH)));
-    int py = int(mod(screen_px.y, float(HEIGHT)));
-    ivec2 p = ivec2(px, py);
-
-    bool on = xbm_bit(p);
-    float v = on ? 1.0 : 0.0;
-    if (invert) v = 1.0 - v;
-    COLOR = mix(bg_color, fg_color, v);
-}
-`)
-	} else {
-		// Spatial variant: ALBEDO/ALPHA
-		buf.WriteString(`void fragment() {
-    vec2 screen_px = floor(SCREEN_UV / SCREEN_PIXEL_SIZE);
-    int px = int(mod(screen_px.x, float(WIDTH)));
-    int py = int(mod(screen_px.y, float(HEIGHT)));
-    ivec2 p = ivec2(px, py);
-
-    bool on = xbm_bit(p);
-    float v = on ? 1.0 : 0.0;
-    if (invert) v = 1.0 - v;
-    ALBEDO = mix(bg_color.rgb, fg_color.rgb, v);
-    ALPHA  = mix(bg_color.a,   fg_color.a,   v);
-}
-`)
-	}
-	return buf.String()
+	if err := xbm.WritePreview(&buf, img, fg, bg, scale); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0o644)
+}
+
+// relTexture is relPath for the companion texture of a resource written
+// at path; no texture stays "".
+func relTexture(path, texPath string) (string, error) {
+	if texPath == "" {
+		return "", nil
+	}
+	return relPath(filepath.Dir(path), texPath)
+}
+
+// relPath returns target relative to dir using forward slashes, as Godot
+// resource paths expect.
+func relPath(dir, target string) (string, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	absTarget, err := filepath.Abs(target)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(absDir, absTarget)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// flagSet reports whether the named flag was given on the command line.
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// stdinIsPipe reports whether stdin is redirected from a pipe or file.
+func stdinIsPipe() bool {
+	fi, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice == 0
+}
+
+// openInput opens path ("-" for stdin) for reading, transparently
+// decompressing gzip data (detected by its magic bytes, whatever the
+// extension).
+func openInput(path string) (io.ReadCloser, error) {
+	f := io.NopCloser(os.Stdin)
+	if path != "-" {
+		var err error
+		if f, err = os.Open(path); err != nil {
+			return nil, err
+		}
+	}
+	br := bufio.NewReader(f)
+	if magic, _ := br.Peek(2); !bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		return readCloser{br, f}, nil
+	}
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("gzip: %w", err)
+	}
+	return readCloser{gzipReader{zr}, f}, nil
+}
+
+// readCloser reads through a wrapper and closes the file beneath it.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// gzipReader marks errors in the compressed data as such.
+type gzipReader struct {
+	r io.Reader
+}
+
+func (g gzipReader) Read(p []byte) (int, error) {
+	n, err := g.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = fmt.Errorf("gzip: %w", err)
+	}
+	return n, err
+}
+
+// readInput reads all of path, opened as openInput does.
+func readInput(path string) ([]byte, error) {
+	r, err := openInput(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// trimExt removes the extension of path, plus a .gz in front of it, so
+// icon.xbm and icon.xbm.gz both give icon.
+func trimExt(path string) string {
+	if strings.EqualFold(filepath.Ext(path), ".gz") {
+		path = path[:len(path)-3]
+	}
+	return strings.TrimSuffix(path, filepath.Ext(path))
+}
+
+func writeOutput(path string, data []byte) error {
+	if path == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+func displayInput(path string) string {
+	if path == "-" {
+		return "stdin"
+	}
+	return path
+}
+
+// envColor replaces *color with the environment variable name when use is
+// true (the colour was not given on the command line) and the variable is
+// set, and reports whether it did. A value that is not a colour is an
+// error rather than silently ignored.
+func envColor(name string, use bool, color *string) (bool, error) {
+	v := strings.TrimSpace(os.Getenv(name))
+	if !use || v == "" {
+		return false, nil
+	}
+	if _, err := xbm.ParseColor(v); err != nil {
+		return false, fmt.Errorf("$%s: %w", name, err)
+	}
+	*color = v
+	return true, nil
+}
+
+// applyPalette replaces *fg and/or *bg with the palette colours at the
+// given indices (-1 leaves a colour alone).
+func applyPalette(path string, fgIndex, bgIndex int, fg, bg *string) error {
+	switch {
+	case path == "":
+		return errors.New("-fgindex and -bgindex need -palette")
+	case fgIndex < 0 && bgIndex < 0:
+		return errors.New("-palette needs -fgindex and/or -bgindex")
+	case fgIndex >= 0 && flagSet("fg"), bgIndex >= 0 && flagSet("bg"):
+		return errors.New("give a colour either as -fg/-bg or as a palette index, not both")
+	}
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	colors, err := xbm.ParseGPL(src)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for _, pick := range []struct {
+		name  string
+		index int
+		dst   *string
+	}{{"-fgindex", fgIndex, fg}, {"-bgindex", bgIndex, bg}} {
+		if pick.index < 0 {
+			continue
+		}
+		if pick.index >= len(colors) {
+			return fmt.Errorf("%s %d is out of range: %s has %d colours (0-%d)", pick.name, pick.index, path, len(colors), len(colors)-1)
+		}
+		*pick.dst = colors[pick.index].String()
+	}
+	return nil
+}
+
+// parseTile parses the -tile value "X,Y" into two positive repeat counts.
+func parseTile(s string) ([2]int, error) {
+	var t [2]int
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return t, fmt.Errorf("-tile wants X,Y, got %q", s)
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil || n <= 0 {
+			return t, fmt.Errorf("-tile values must be positive integers, got %q", s)
+		}
+		t[i] = n
+	}
+	return t, nil
+}
+
+func displayPath(path string) string {
+	if path == "-" {
+		return "stdout"
+	}
+	return path
 }
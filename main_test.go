@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ganehag/xbm2gdshader/xbm"
+)
+
+func TestCheckColourSpellings(t *testing.T) {
+	// -check must not report a shader stale when a colour is only
+	// spelled differently from the build
+	dir := t.TempDir()
+	in := filepath.Join(dir, "a.xbm")
+	src := "#define a_width 8\n#define a_height 2\nstatic char a_bits[] = { 0x81, 0x7E };\n"
+	if err := os.WriteFile(in, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "a.gdshader")
+	build := &converter{opts: xbm.Options{FG: "blue", BG: "#fff", Outline: "Red"}, types: []string{"canvas_item"}, quiet: true}
+	if _, err := build.convert(in, out); err != nil {
+		t.Fatal(err)
+	}
+	check := &converter{opts: xbm.Options{FG: "#0000FF", BG: "white", Outline: "#ff0000ff"}, types: []string{"canvas_item"}}
+	if err := check.checkStale(in, out); err != nil {
+		t.Error(err)
+	}
+	check.opts.FG = "navy"
+	if err := check.checkStale(in, out); err == nil {
+		t.Error("a different colour passed -check")
+	}
+}
@@ -0,0 +1,39 @@
+package xbm
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// ParseASCII reads a hand-drawn text grid, one row per line: every on
+// character (such as '#') becomes a set bit and any other character a
+// clear one. The width is that of the longest line, counted in characters,
+// and shorter lines are padded with clear bits; the height is the number of
+// lines, not counting blank lines at the end. Zero on means '#'.
+func ParseASCII(src []byte, on rune) (Image, error) {
+	if on == 0 {
+		on = '#'
+	}
+	text := strings.TrimRight(normalizeText(string(src)), "\n")
+	if strings.TrimSpace(text) == "" {
+		return Image{}, errors.New("ascii: empty grid")
+	}
+	lines := strings.Split(text, "\n")
+	w := 0
+	for _, line := range lines {
+		w = max(w, utf8.RuneCountInString(line))
+	}
+
+	img := newImage(w, len(lines))
+	for y, line := range lines {
+		x := 0
+		for _, r := range line {
+			if r == on {
+				img.set(x, y)
+			}
+			x++
+		}
+	}
+	return img, nil
+}
@@ -0,0 +1,55 @@
+package xbm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"regexp"
+)
+
+var reSource = regexp.MustCompile(`(?m)^// source: (\S+)`)
+
+// Checksum identifies a generation: a shortened SHA-256 of the input
+// bytes and settings, a free-form description of everything else that
+// shapes the output (typically the options formatted with %+v). Setting it
+// as Options.Checksum embeds it in the shader for EmbeddedChecksum to find.
+func Checksum(src []byte, settings string) string {
+	d := NewDigest()
+	d.Write(src)
+	return d.Sum(settings)
+}
+
+// Digest computes Checksum over a source written to it in pieces, so a
+// large input never has to be held in memory: after writing src,
+// Sum(settings) is Checksum(src, settings).
+type Digest struct {
+	h hash.Hash
+}
+
+// NewDigest returns an empty Digest.
+func NewDigest() *Digest {
+	return &Digest{h: sha256.New()}
+}
+
+// Write adds p to the source. It never fails.
+func (d *Digest) Write(p []byte) (int, error) {
+	return d.h.Write(p)
+}
+
+// Sum returns the checksum of the source written so far with settings.
+// It finishes the digest: nothing may be written or summed after it.
+func (d *Digest) Sum(settings string) string {
+	d.h.Write([]byte{0})
+	d.h.Write([]byte(settings))
+	return "sha256:" + hex.EncodeToString(d.h.Sum(nil))[:16]
+}
+
+// EmbeddedChecksum returns the checksum in the "// source:" line of a
+// generated shader, if it has one.
+func EmbeddedChecksum(shader []byte) (string, bool) {
+	m := reSource.FindSubmatch(shader)
+	if m == nil {
+		return "", false
+	}
+	return string(m[1]), true
+}
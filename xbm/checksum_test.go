@@ -0,0 +1,14 @@
+package xbm
+
+import "testing"
+
+func TestDigest(t *testing.T) {
+	src := []byte("#define a_width 8\n#define a_height 1\nstatic char a_bits[] = { 0x01 };\n")
+	d := NewDigest()
+	for i := 0; i < len(src); i += 5 {
+		d.Write(src[i:min(i+5, len(src))])
+	}
+	if got, want := d.Sum("invert=true"), Checksum(src, "invert=true"); got != want {
+		t.Errorf("Digest.Sum = %s, want Checksum's %s", got, want)
+	}
+}
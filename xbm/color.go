@@ -0,0 +1,133 @@
+package xbm
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Color is an 8-bit RGBA colour.
+type Color struct {
+	R, G, B, A uint8
+}
+
+// namedColors are the CSS basic colour keywords plus a few common extras.
+var namedColors = map[string]Color{
+	"transparent": {0x00, 0x00, 0x00, 0x00},
+	"black":       {0x00, 0x00, 0x00, 0xFF},
+	"white":       {0xFF, 0xFF, 0xFF, 0xFF},
+	"red":         {0xFF, 0x00, 0x00, 0xFF},
+	"green":       {0x00, 0x80, 0x00, 0xFF},
+	"lime":        {0x00, 0xFF, 0x00, 0xFF},
+	"blue":        {0x00, 0x00, 0xFF, 0xFF},
+	"yellow":      {0xFF, 0xFF, 0x00, 0xFF},
+	"cyan":        {0x00, 0xFF, 0xFF, 0xFF},
+	"aqua":        {0x00, 0xFF, 0xFF, 0xFF},
+	"magenta":     {0xFF, 0x00, 0xFF, 0xFF},
+	"fuchsia":     {0xFF, 0x00, 0xFF, 0xFF},
+	"gray":        {0x80, 0x80, 0x80, 0xFF},
+	"grey":        {0x80, 0x80, 0x80, 0xFF},
+	"silver":      {0xC0, 0xC0, 0xC0, 0xFF},
+	"maroon":      {0x80, 0x00, 0x00, 0xFF},
+	"olive":       {0x80, 0x80, 0x00, 0xFF},
+	"navy":        {0x00, 0x00, 0x80, 0xFF},
+	"purple":      {0x80, 0x00, 0x80, 0xFF},
+	"teal":        {0x00, 0x80, 0x80, 0xFF},
+	"orange":      {0xFF, 0xA5, 0x00, 0xFF},
+}
+
+// ParseColor accepts a hex colour (#RRGGBBAA, #RRGGBB or #RGB; alpha
+// defaults to FF) or a CSS colour name such as "red" or "transparent"
+// (case-insensitive). Surrounding whitespace is ignored.
+func ParseColor(s string) (Color, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "#") {
+		return parseHex(s)
+	}
+	if c, ok := namedColors[strings.ToLower(s)]; ok {
+		return c, nil
+	}
+	// Bare RRGGBBAA without the '#' has always been accepted.
+	if len(s) == 8 {
+		if c, err := parseHex(s); err == nil {
+			return c, nil
+		}
+	}
+	return Color{}, fmt.Errorf("%q is neither a #RRGGBBAA hex colour nor a known colour name", s)
+}
+
+// String returns c as "#RRGGBBAA", which ParseColor reads back.
+func (c Color) String() string {
+	return fmt.Sprintf("#%02X%02X%02X%02X", c.R, c.G, c.B, c.A)
+}
+
+func parseHex(hex string) (Color, error) {
+	s := strings.TrimPrefix(hex, "#")
+	switch len(s) {
+	case 8:
+	case 6:
+		s += "FF"
+	case 3:
+		// #RGB shorthand: each nibble is doubled
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2], 'F', 'F'})
+	default:
+		return Color{}, fmt.Errorf("want #RRGGBBAA, #RRGGBB or #RGB, got %q", hex)
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return Color{}, fmt.Errorf("bad hex colour %q", hex)
+	}
+	return Color{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
+
+// floats returns c's components in 0..1, formatted to six significant
+// digits (see component). With linear set, RGB is converted from sRGB to
+// linear light using the standard sRGB transfer function; alpha is never
+// converted.
+func (c Color) floats(linear bool) [4]string {
+	v := [4]float64{float64(c.R) / 255, float64(c.G) / 255, float64(c.B) / 255, float64(c.A) / 255}
+	var f [4]string
+	for i := range v {
+		if linear && i < 3 {
+			v[i] = srgbToLinear(v[i])
+		}
+		f[i] = component(v[i])
+	}
+	return f
+}
+
+func srgbToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// component formats a colour component with a fixed six significant
+// digits, well past 8-bit precision. The shortest round-trip form would
+// expose last-bit differences in math.Pow or fused multiply-adds between
+// platforms, and shaders should be byte-identical wherever they are built.
+func component(v float64) string {
+	return strconv.FormatFloat(v, 'g', 6, 64)
+}
+
+// vec4 formats c as a GLSL vec4 literal with components in 0..1.
+func (c Color) vec4(linear bool) string {
+	f := c.floats(linear)
+	return fmt.Sprintf("vec4(%s,%s,%s,%s)", f[0], f[1], f[2], f[3])
+}
+
+// ivec4 formats c's bytes as a GLSL ivec4 literal (ColorFormat "int").
+func (c Color) ivec4() string {
+	return fmt.Sprintf("ivec4(%d, %d, %d, %d)", c.R, c.G, c.B, c.A)
+}
+
+// godotColor formats c as a Color(...) constructor for Godot resource files.
+func (c Color) godotColor(godot int, linear bool) string {
+	f := c.floats(linear)
+	if godot == 3 {
+		return fmt.Sprintf("Color( %s, %s, %s, %s )", f[0], f[1], f[2], f[3])
+	}
+	return fmt.Sprintf("Color(%s, %s, %s, %s)", f[0], f[1], f[2], f[3])
+}
@@ -0,0 +1,16 @@
+package xbm
+
+import "testing"
+
+func TestParseColorHex(t *testing.T) {
+	for _, s := range []string{"#F00", "#FF0000", "#FF0000FF", " #FF0000", "#ff0000 ", "\tFF0000FF\n"} {
+		c, err := ParseColor(s)
+		if err != nil {
+			t.Errorf("ParseColor(%q): %v", s, err)
+			continue
+		}
+		if got, want := c.vec4(false), "vec4(1,0,0,1)"; got != want {
+			t.Errorf("ParseColor(%q).vec4() = %s, want %s", s, got, want)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package xbm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// WriteXBM writes img as a canonical XBM named name: the _width and
+// _height #defines (and _x_hot/_y_hot if the hotspot is not at the
+// origin), then a static unsigned char name_bits[] array of LSB-first,
+// byte-padded rows, twelve bytes to a line as X11's bitmap writes them.
+// Padding bits are always zero, whatever the source held.
+func WriteXBM(w io.Writer, img Image, name string) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "#define %s_width %d\n", name, img.Width)
+	fmt.Fprintf(bw, "#define %s_height %d\n", name, img.Height)
+	if img.XHot != 0 || img.YHot != 0 {
+		fmt.Fprintf(bw, "#define %s_x_hot %d\n", name, img.XHot)
+		fmt.Fprintf(bw, "#define %s_y_hot %d\n", name, img.YHot)
+	}
+	fmt.Fprintf(bw, "static unsigned char %s_bits[] = {", name)
+
+	rowBytes := (img.Width + 7) / 8
+	n := rowBytes * img.Height
+	for i := 0; i < n; i++ {
+		y, x0 := i/rowBytes, (i%rowBytes)*8
+		var b byte
+		for x := x0; x < min(x0+8, img.Width); x++ {
+			if img.At(x, y) {
+				b |= 1 << uint(x-x0)
+			}
+		}
+		switch {
+		case i%12 == 0:
+			bw.WriteString("\n   ")
+		default:
+			bw.WriteString(" ")
+		}
+		fmt.Fprintf(bw, "0x%02x", b)
+		if i < n-1 {
+			bw.WriteString(",")
+		}
+	}
+	bw.WriteString(" };\n")
+	return bw.Flush()
+}
@@ -0,0 +1,25 @@
+package xbm
+
+import "errors"
+
+// Sentinel errors returned (possibly wrapped with details) by the parsers.
+// Test for them with errors.Is.
+var (
+	// ErrNoDefines means an XBM source lacks its _width/_height #defines.
+	ErrNoDefines = errors.New("missing width/height #defines")
+	// ErrNoBits means an XBM source has no "<name>_bits[] = { ... };" array.
+	ErrNoBits = errors.New("missing bits array")
+	// ErrZeroSize means an image is 0 pixels wide or high, which no shader
+	// can hold.
+	ErrZeroSize = errors.New("image has zero width or height")
+	// ErrEmptyBits means the bits array contains no numbers.
+	ErrEmptyBits = errors.New("no numbers found in bits array")
+	// ErrShortBits means the bits array holds fewer bytes than the
+	// #defines require.
+	ErrShortBits = errors.New("bits array too short")
+	// ErrLongBits means the bits array has non-zero bytes past the end of
+	// the image.
+	ErrLongBits = errors.New("bits array too long")
+	// ErrTooManyColors means an XPM image has more than two colours.
+	ErrTooManyColors = errors.New("only two-colour images can be converted")
+)
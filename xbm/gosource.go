@@ -0,0 +1,25 @@
+package xbm
+
+import (
+	"fmt"
+	"go/token"
+	"strings"
+)
+
+// GoSource returns a Go source file of package pkg that declares shader as
+// the raw string constant Shader, so a Go program can embed a generated
+// shader at compile time. Backticks and carriage returns, which a raw
+// string cannot hold, are spliced in as interpreted strings.
+func GoSource(pkg, shader string) (string, error) {
+	if !token.IsIdentifier(pkg) || pkg == "_" {
+		return "", fmt.Errorf("invalid Go package name %q", pkg)
+	}
+	var b strings.Builder
+	b.WriteString("// Code generated by xbm2gdshader. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&b, "package %s\n\n", pkg)
+	b.WriteString("// Shader is the generated Godot shader code.\n")
+	b.WriteString("const Shader = `")
+	b.WriteString(strings.NewReplacer("`", "` + \"`\" + `", "\r", "` + \"\\r\" + `").Replace(shader))
+	b.WriteString("`\n")
+	return b.String(), nil
+}
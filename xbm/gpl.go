@@ -0,0 +1,48 @@
+package xbm
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseGPL reads a GIMP palette (.gpl): a "GIMP Palette" header, optional
+// "Name:"/"Columns:" lines and "#" comments, then one "R G B [name]" row per
+// colour. The colours are returned in file order, fully opaque.
+func ParseGPL(src []byte) ([]Color, error) {
+	sc := bufio.NewScanner(bytes.NewReader(src))
+	if !sc.Scan() || strings.TrimSpace(sc.Text()) != "GIMP Palette" {
+		return nil, fmt.Errorf("gpl: missing \"GIMP Palette\" header")
+	}
+
+	var colors []Color
+	for line := 2; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") ||
+			strings.HasPrefix(text, "Name:") || strings.HasPrefix(text, "Columns:") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("gpl: line %d: want \"R G B [name]\", got %q", line, text)
+		}
+		var rgb [3]uint8
+		for i := range rgb {
+			v, err := strconv.Atoi(fields[i])
+			if err != nil || v < 0 || v > 255 {
+				return nil, fmt.Errorf("gpl: line %d: bad channel value %q", line, fields[i])
+			}
+			rgb[i] = uint8(v)
+		}
+		colors = append(colors, Color{rgb[0], rgb[1], rgb[2], 0xFF})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("gpl: %w", err)
+	}
+	if len(colors) == 0 {
+		return nil, fmt.Errorf("gpl: no colours")
+	}
+	return colors, nil
+}
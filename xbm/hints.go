@@ -0,0 +1,36 @@
+package xbm
+
+import "regexp"
+
+var reHint = regexp.MustCompile(`\b(fg|bg)\s*:\s*(#?[0-9A-Za-z]+)`)
+
+// ColorHints returns the colours named in the C comments of an XBM or XPM
+// source, using the convention
+//
+//	/* fg: #ff0000 */
+//	/* fg: red  bg: #00000000 */
+//	// bg: transparent
+//
+// Each value is anything ParseColor accepts; other values are skipped, so
+// ordinary comment text is never mistaken for a colour. The first valid hint
+// for each colour wins; a missing hint is returned as "". Other formats have no comments
+// and yield no hints.
+func ColorHints(src []byte) (fg, bg string) {
+	if f := Format(src); f != "xbm" && f != "xpm" {
+		return "", ""
+	}
+	for _, c := range reCComment.FindAll(src, -1) {
+		for _, m := range reHint.FindAllSubmatch(c, -1) {
+			if _, err := ParseColor(string(m[2])); err != nil {
+				continue // prose such as "bg: none" or "bg: the background"
+			}
+			switch {
+			case string(m[1]) == "fg" && fg == "":
+				fg = string(m[2])
+			case string(m[1]) == "bg" && bg == "":
+				bg = string(m[2])
+			}
+		}
+	}
+	return fg, bg
+}
@@ -0,0 +1,22 @@
+package xbm
+
+import "testing"
+
+func TestColorHints(t *testing.T) {
+	tests := []struct {
+		src, fg, bg string
+	}{
+		{"/* fg: #ff0000 */\n#define a_width 1\n", "#ff0000", ""},
+		{"/* fg: red  bg: #00000000 */\n#define a_width 1\n", "red", "#00000000"},
+		{"// bg: transparent\n#define a_width 1\n", "", "transparent"},
+		// Prose that only looks like a hint is skipped
+		{"/* bg: none */\n/* bg: the background */\n#define a_width 1\n", "", ""},
+		{"/* bg: none, bg: blue */\n#define a_width 1\n", "", "blue"},
+	}
+	for _, tt := range tests {
+		fg, bg := ColorHints([]byte(tt.src))
+		if fg != tt.fg || bg != tt.bg {
+			t.Errorf("ColorHints(%q) = %q, %q, want %q, %q", tt.src, fg, bg, tt.fg, tt.bg)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package xbm
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// isICO reports whether src starts with a Windows icon (.ico) or cursor
+// (.cur) directory header.
+func isICO(src []byte) bool {
+	if len(src) < 6 || src[0] != 0 || src[1] != 0 || src[3] != 0 {
+		return false
+	}
+	return (src[2] == 1 || src[2] == 2) && binary.LittleEndian.Uint16(src[4:]) > 0
+}
+
+// ParseICO reads the 1-bit AND mask of a Windows icon or cursor. Of the
+// uncompressed (BMP) images in the directory the largest is used; PNG
+// compressed entries have no mask and are skipped. Opaque pixels (mask
+// bit 0) become foreground bits, and a cursor's hotspot is kept.
+func ParseICO(src []byte) (Image, error) {
+	if !isICO(src) {
+		return Image{}, fmt.Errorf("ico: missing icon directory header")
+	}
+	cursor := src[2] == 2
+	count := int(binary.LittleEndian.Uint16(src[4:]))
+	if len(src) < 6+16*count {
+		return Image{}, fmt.Errorf("ico: directory truncated: want %d entries", count)
+	}
+
+	best, bestArea := -1, 0
+	for i := 0; i < count; i++ {
+		e := src[6+16*i:]
+		off := int(binary.LittleEndian.Uint32(e[12:]))
+		if off < 0 || off+8 > len(src) || string(src[off+1:off+4]) == "PNG" {
+			continue
+		}
+		w, h := int(e[0]), int(e[1])
+		if w == 0 {
+			w = 256
+		}
+		if h == 0 {
+			h = 256
+		}
+		if w*h > bestArea {
+			best, bestArea = i, w*h
+		}
+	}
+	if best < 0 {
+		return Image{}, fmt.Errorf("ico: no uncompressed image in %d entries (PNG icons have no mask)", count)
+	}
+
+	e := src[6+16*best:]
+	img, err := icoMask(src[binary.LittleEndian.Uint32(e[12:]):])
+	if err != nil {
+		return Image{}, fmt.Errorf("ico: entry %d: %w", best, err)
+	}
+	if cursor {
+		img.XHot = int(binary.LittleEndian.Uint16(e[4:]))
+		img.YHot = int(binary.LittleEndian.Uint16(e[6:]))
+	}
+	return img, nil
+}
+
+// icoMask decodes the AND mask following the colour (XOR) bitmap of one
+// BITMAPINFOHEADER icon image. Both bitmaps have rows padded to 32 bits and
+// stored bottom-up; the header height counts both of them.
+func icoMask(b []byte) (Image, error) {
+	if len(b) < 40 {
+		return Image{}, fmt.Errorf("bitmap header truncated")
+	}
+	le := binary.LittleEndian
+	size := int(le.Uint32(b))
+	w := int(int32(le.Uint32(b[4:])))
+	h := int(int32(le.Uint32(b[8:]))) / 2
+	bpp := int(le.Uint16(b[14:]))
+	if c := le.Uint32(b[16:]); c != 0 {
+		return Image{}, fmt.Errorf("compressed bitmap (type %d) not supported", c)
+	}
+	// Icons are at most 256×256; with that and the palette bounded too,
+	// the strides and offsets below cannot overflow
+	switch {
+	case size < 40 || size > len(b) || w <= 0 || h <= 0 || w > 256 || h > 256:
+		return Image{}, fmt.Errorf("bad bitmap header (%dx%d, %d-byte header)", w, h, size)
+	case bpp != 1 && bpp != 4 && bpp != 8 && bpp != 16 && bpp != 24 && bpp != 32:
+		return Image{}, fmt.Errorf("bad bitmap header (%d bpp)", bpp)
+	}
+	colors := 0
+	if bpp <= 8 {
+		if colors = int(le.Uint32(b[32:])); colors == 0 {
+			colors = 1 << uint(bpp)
+		}
+		if colors > 1<<uint(bpp) {
+			return Image{}, fmt.Errorf("bad bitmap header (%d colours at %d bpp)", colors, bpp)
+		}
+	}
+
+	xorStride := (w*bpp + 31) / 32 * 4
+	andStride := (w + 31) / 32 * 4
+	pos := size + 4*colors + xorStride*h
+	if want := pos + andStride*h; len(b) < want {
+		return Image{}, fmt.Errorf("mask truncated: want %d bytes, have %d", want, len(b))
+	}
+
+	img := newImage(w, h)
+	for y := 0; y < h; y++ {
+		row := b[pos+(h-1-y)*andStride:]
+		for x := 0; x < w; x++ {
+			if row[x>>3]&(0x80>>uint(x&7)) == 0 {
+				img.set(x, y)
+			}
+		}
+	}
+	return img, nil
+}
@@ -0,0 +1,61 @@
+package xbm
+
+import (
+	"encoding/binary"
+	"slices"
+	"testing"
+)
+
+// icoFile returns a one-entry icon directory followed by a 1 bpp bitmap
+// header for w×h, its two-colour palette and mask rows, bottom row first.
+func icoFile(w, h int, mask ...byte) []byte {
+	le := binary.LittleEndian
+	b := []byte{0, 0, 1, 0, 1, 0}
+	entry := make([]byte, 16)
+	entry[0], entry[1] = byte(w), byte(h)
+	le.PutUint32(entry[12:], 22)
+	b = append(b, entry...)
+
+	hdr := make([]byte, 40)
+	le.PutUint32(hdr, 40)
+	le.PutUint32(hdr[4:], uint32(w))
+	le.PutUint32(hdr[8:], uint32(2*h))
+	le.PutUint16(hdr[12:], 1)
+	le.PutUint16(hdr[14:], 1)
+	b = append(b, hdr...)
+	b = append(b, make([]byte, 8)...)   // palette
+	b = append(b, make([]byte, 4*h)...) // colour bitmap
+	return append(b, mask...)
+}
+
+func TestParseICO(t *testing.T) {
+	// Mask rows bottom-up, 32-bit padded; clear bits are opaque
+	src := icoFile(3, 2,
+		0x3F, 0, 0, 0, // bottom: ##.
+		0x5F, 0, 0, 0, // top:    #.#
+	)
+	img, err := ParseICO(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rows(img), []string{"#.#", "##."}; !slices.Equal(got, want) {
+		t.Errorf("ParseICO = %q, want %q", got, want)
+	}
+}
+
+func TestParseICOHugeHeader(t *testing.T) {
+	le := binary.LittleEndian
+	for _, hdr := range [][3]uint32{
+		{0x7fffffff, 0x3fffffff, 0xffff},
+		{257, 1, 1},
+		{16, 16, 3},
+	} {
+		src := icoFile(16, 16, make([]byte, 64)...)
+		le.PutUint32(src[22+4:], hdr[0])
+		le.PutUint32(src[22+8:], 2*hdr[1])
+		le.PutUint16(src[22+14:], uint16(hdr[2]))
+		if _, err := ParseICO(src); err == nil {
+			t.Errorf("ParseICO(%dx%d, %d bpp): got no error", hdr[0], hdr[1], hdr[2])
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package xbm
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var reIdent = regexp.MustCompile(`^[A-Za-z_]\w*$`)
+
+// reserved are Godot shading language keywords, types and built-ins, and
+// the identifiers the generated shader itself declares (locals included),
+// none of which may be reused as a uniform name.
+var reserved = map[string]bool{
+	// Keywords
+	"shader_type": true, "render_mode": true, "uniform": true, "instance": true,
+	"global": true, "const": true, "varying": true, "in": true, "out": true,
+	"inout": true, "flat": true, "smooth": true, "lowp": true, "mediump": true,
+	"highp": true, "if": true, "else": true, "for": true, "while": true,
+	"do": true, "switch": true, "case": true, "default": true, "break": true,
+	"continue": true, "return": true, "discard": true, "struct": true,
+	"true": true, "false": true,
+	// Types
+	"void": true, "bool": true, "bvec2": true, "bvec3": true, "bvec4": true,
+	"int": true, "ivec2": true, "ivec3": true, "ivec4": true,
+	"uint": true, "uvec2": true, "uvec3": true, "uvec4": true,
+	"float": true, "vec2": true, "vec3": true, "vec4": true,
+	"mat2": true, "mat3": true, "mat4": true,
+	"sampler2D": true, "isampler2D": true, "usampler2D": true,
+	"sampler2DArray": true, "isampler2DArray": true, "usampler2DArray": true,
+	"sampler3D": true, "isampler3D": true, "usampler3D": true,
+	"samplerCube": true, "samplerCubeArray": true,
+	// Generated symbols
+	"WIDTH": true, "HEIGHT": true, "WORDS": true, "SCALE": true, "DATA": true,
+	"HOTSPOT": true, "FRAMES": true, "fps": true,
+	"bitmap": true, "xbm_bit": true, "xbm_wrap": true, "xbm_smooth": true, "xbm_color": true,
+	"fragment": true, "outline_color": true, "rotation": true, "xbm_rotate": true,
+	"tile_repeat": true, "glyph_index": true, "RUNS": true, "xbm_rgb": true,
+	"xbm_alpha": true, "CHUNK": true, "blink_hz": true, "fg_col": true,
+	"MASK": true, "xbm_mask": true,
+	// Locals and parameters of the generated functions
+	"v": true, "col": true, "p": true, "px": true, "py": true, "on": true,
+	"screen_px": true, "uv_px": true, "tile_px": true, "rect_size": true, "pos": true,
+	"frame": true, "grad": true, "edge": true, "idx": true, "w": true,
+	"corner_p": true, "corner_h": true, "corner_r": true, "corner_q": true,
+	"corner_d": true, "i": true, "t": true, "a": true, "b": true, "c": true,
+	"d": true, "f": true, "k": true, "lo": true, "hi": true, "s": true,
+	"q": true, "centre": true, "size": true, "uv": true,
+	// Godot built-ins the shaders read or write
+	"COLOR": true, "UV": true, "TIME": true, "TEXTURE": true,
+	"TEXTURE_PIXEL_SIZE": true, "SCREEN_UV": true, "SCREEN_PIXEL_SIZE": true,
+	"FRAGCOORD": true, "VIEWPORT_SIZE": true, "VERTEX": true, "NORMAL": true,
+	"ALBEDO": true, "ALPHA": true, "PI": true, "TAU": true, "E": true,
+}
+
+// checkIdent reports whether name can be used as a shader identifier.
+func checkIdent(name string) error {
+	if !reIdent.MatchString(name) {
+		return fmt.Errorf("%q is not a valid identifier", name)
+	}
+	if reserved[name] {
+		return fmt.Errorf("%q is a reserved word", name)
+	}
+	if strings.HasPrefix(name, "gl_") {
+		return fmt.Errorf("%q uses the reserved gl_ prefix", name)
+	}
+	return nil
+}
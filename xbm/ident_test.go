@@ -0,0 +1,17 @@
+package xbm
+
+import "testing"
+
+func TestCheckIdent(t *testing.T) {
+	for _, name := range []string{"ink", "paper", "logo_fg", "_x1"} {
+		if err := checkIdent(name); err != nil {
+			t.Errorf("checkIdent(%q) = %v, want nil", name, err)
+		}
+	}
+	// Keywords, built-ins and names the generated shader declares itself
+	for _, name := range []string{"", "1x", "gl_x", "uniform", "vec4", "COLOR", "TIME", "col", "v", "screen_px", "corner_d", "xbm_bit"} {
+		if err := checkIdent(name); err == nil {
+			t.Errorf("checkIdent(%q) = nil, want an error", name)
+		}
+	}
+}
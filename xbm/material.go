@@ -0,0 +1,153 @@
+package xbm
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// preset is the value of one per-node uniform, formatted for a .tres or
+// .tscn file.
+type preset struct{ name, value string }
+
+// presets returns the per-node uniforms of the shader built from opts with
+// their values: fg_color, bg_color and invert (invert is skipped when
+// opts.NoInvertUniform is set, bg_color with opts.OverTexture and in favour
+// of bg_color_top/bg_color_bottom with opts.BGGradient), plus
+// outline_color, rotation, blink_hz, glyph_index and tile_repeat when the
+// shader has them.
+func presets(opts Options) ([]preset, error) {
+	fg, err := ParseColor(opts.FG)
+	if err != nil {
+		return nil, err
+	}
+	bg, err := ParseColor(opts.BG)
+	if err != nil {
+		return nil, err
+	}
+	var grad [2]Color
+	if opts.gradient() {
+		for i, c := range opts.BGGradient {
+			if grad[i], err = ParseColor(c); err != nil {
+				return nil, fmt.Errorf("bg gradient: %w", err)
+			}
+		}
+	}
+
+	var outline *Color
+	if opts.Outline != "" {
+		oc, err := ParseColor(opts.Outline)
+		if err != nil {
+			return nil, fmt.Errorf("outline: %w", err)
+		}
+		outline = &oc
+	}
+
+	n := opts.names()
+	if err := n.check(); err != nil {
+		return nil, err
+	}
+	if opts.ColorFormat == "int" && opts.Godot == 3 {
+		return nil, fmt.Errorf("int colours need Godot 4")
+	}
+
+	color := func(c Color) string {
+		switch {
+		case opts.Godot == 3:
+			return c.godotColor(3, opts.linear())
+		case opts.ColorFormat == "int":
+			return fmt.Sprintf("Vector4i(%d, %d, %d, %d)", c.R, c.G, c.B, c.A)
+		}
+		return c.godotColor(4, opts.linear())
+	}
+	ps := []preset{{n.fg, color(fg)}}
+	switch {
+	case opts.overTexture():
+	case opts.gradient():
+		ps = append(ps, preset{n.bg + "_top", color(grad[0])}, preset{n.bg + "_bottom", color(grad[1])})
+	default:
+		ps = append(ps, preset{n.bg, color(bg)})
+	}
+	if !opts.NoInvertUniform {
+		ps = append(ps, preset{n.invert, "false"})
+	}
+	if outline != nil {
+		ps = append(ps, preset{"outline_color", color(*outline)})
+	}
+	if opts.EmitRotation {
+		ps = append(ps, preset{"rotation", "0.0"})
+	}
+	if opts.EmitBlink {
+		ps = append(ps, preset{"blink_hz", "0.0"})
+	}
+	if opts.Atlas && opts.Frames > 1 {
+		ps = append(ps, preset{"glyph_index", "0"})
+	}
+	if opts.Tile != [2]int{} {
+		tile := fmt.Sprintf("Vector2i(%d, %d)", opts.Tile[0], opts.Tile[1])
+		if opts.Godot == 3 {
+			tile = fmt.Sprintf("Vector2( %d, %d )", opts.Tile[0], opts.Tile[1])
+		}
+		ps = append(ps, preset{"tile_repeat", tile})
+	}
+	return ps, nil
+}
+
+// BuildMaterial returns a text ShaderMaterial resource (.tres) that uses
+// the shader at shaderPath and presets its per-node uniforms from opts
+// (see presets). A material cannot set instance uniforms, so the presets
+// are left out for Godot 4 unless opts.PlainUniforms is set. In the
+// texture modes the bitmap uniform is bound to opts.Texture, if set.
+// shaderPath is written verbatim, so it should be a res:// path or
+// relative to the directory the .tres is saved in.
+func BuildMaterial(shaderPath string, opts Options) (string, error) {
+	opts = opts.withDefaults()
+	ps, err := presets(opts)
+	if err != nil {
+		return "", err
+	}
+	if opts.instanceUniforms() {
+		ps = nil
+	}
+
+	texture := opts.textured() && opts.Texture != ""
+	steps := 2 // the shader and the material itself
+	if texture {
+		steps++
+	}
+
+	var buf bytes.Buffer
+	if opts.Godot == 3 {
+		fmt.Fprintf(&buf, "[gd_resource type=\"ShaderMaterial\" load_steps=%d format=2]\n\n", steps)
+		fmt.Fprintf(&buf, "[ext_resource path=%q type=\"Shader\" id=1]\n", shaderPath)
+		if texture {
+			fmt.Fprintf(&buf, "[ext_resource path=%q type=\"Texture\" id=2]\n", opts.Texture)
+		}
+		buf.WriteString("\n[resource]\n")
+		buf.WriteString("shader = ExtResource( 1 )\n")
+		if texture {
+			buf.WriteString("shader_param/bitmap = ExtResource( 2 )\n")
+		}
+		writePresets(&buf, "shader_param/", ps)
+		return buf.String(), nil
+	}
+
+	fmt.Fprintf(&buf, "[gd_resource type=\"ShaderMaterial\" load_steps=%d format=3]\n\n", steps)
+	fmt.Fprintf(&buf, "[ext_resource type=\"Shader\" path=%q id=\"1\"]\n", shaderPath)
+	if texture {
+		fmt.Fprintf(&buf, "[ext_resource type=\"Texture2D\" path=%q id=\"2\"]\n", opts.Texture)
+	}
+	buf.WriteString("\n[resource]\n")
+	buf.WriteString("shader = ExtResource(\"1\")\n")
+	if texture {
+		buf.WriteString("shader_parameter/bitmap = ExtResource(\"2\")\n")
+	}
+	writePresets(&buf, "shader_parameter/", ps)
+	return buf.String(), nil
+}
+
+// writePresets writes each preset as a property named prefix+name.
+func writePresets(buf *bytes.Buffer, prefix string, ps []preset) {
+	for _, p := range ps {
+		fmt.Fprintf(buf, "%s%s = %s\n", prefix, p.name, p.value)
+	}
+}
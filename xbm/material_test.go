@@ -0,0 +1,71 @@
+package xbm
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var (
+	reParam   = regexp.MustCompile(`(?m)^(shader_param|shader_parameter|instance_shader_parameters)/(\w+) = `)
+	reUniform = regexp.MustCompile(`(?m)^(instance uniform|uniform) \w+ (\w+)\b`)
+)
+
+// TestPresetUniformKind checks that every value a material or scene
+// presets is set the way its uniform is declared: shader parameters for
+// plain uniforms, instance shader parameters on the node for instance
+// uniforms, which a material cannot set.
+func TestPresetUniformKind(t *testing.T) {
+	img := pattern(16, 8)
+	base := Options{ShaderType: "canvas_item", FG: "#336699", Outline: "red", EmitRotation: true, EmitBlink: true, Tile: [2]int{2, 3}}
+	tests := []struct {
+		name  string
+		godot int
+		plain bool
+		ref   string
+	}{
+		{"instance uniforms, scene", 4, false, "x.gdshader"},
+		{"plain uniforms, material", 4, true, "x.tres"},
+		{"plain uniforms, scene", 4, true, "x.gdshader"},
+		{"godot 3, material", 3, false, "x.tres"},
+		{"godot 3, scene", 3, false, "x.shader"},
+	}
+	for _, tt := range tests {
+		opts := base
+		opts.Godot, opts.PlainUniforms = tt.godot, tt.plain
+		shader, err := BuildShader(img, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		kinds := map[string]string{}
+		for _, m := range reUniform.FindAllStringSubmatch(shader, -1) {
+			kinds[m[2]] = m[1]
+		}
+
+		var out string
+		if strings.HasSuffix(tt.ref, ".tres") {
+			out, err = BuildMaterial("x.shader", opts)
+		} else {
+			out, err = BuildScene(tt.ref, img, opts)
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		set := map[string]bool{}
+		for _, m := range reParam.FindAllStringSubmatch(out, -1) {
+			want := "uniform"
+			if m[1] == "instance_shader_parameters" {
+				want = "instance uniform"
+			}
+			if kinds[m[2]] != want {
+				t.Errorf("%s: %s/%s set for a %q declaration, want %q", tt.name, m[1], m[2], kinds[m[2]], want)
+			}
+			set[m[2]] = true
+		}
+		for name := range kinds {
+			if name != "fps" && !set[name] {
+				t.Errorf("%s: uniform %s not preset", tt.name, name)
+			}
+		}
+	}
+}
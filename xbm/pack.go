@@ -0,0 +1,75 @@
+package xbm
+
+import "math/bits"
+
+// BitOrder is the order of pixels within each byte of the bits array.
+type BitOrder int
+
+const (
+	// LSBFirst is standard XBM: the least significant bit is the leftmost pixel.
+	LSBFirst BitOrder = iota
+	// MSBFirst is used by some older tools: the most significant bit is the leftmost pixel.
+	MSBFirst
+)
+
+// RepackBitsToU32 converts raw XBM bytes into a tight bitstream.
+//
+// XBM rows are byte-padded, LSB-first within each byte (unless order is MSBFirst).
+// Repack to tight bitstream (width*height bits) → 32-bit words for the shader.
+func RepackBitsToU32(xbm []byte, w, h int, order BitOrder) []uint32 {
+	rowBytes := (w + 7) / 8
+	totalBits := w * h
+	words := (totalBits + 31) / 32
+	dst := make([]uint32, words)
+
+	setBit := func(i int) {
+		dst[i>>5] |= 1 << uint(i&31)
+	}
+
+	outIdx := 0
+	for y := 0; y < h; y++ {
+		base := y * rowBytes
+		for x := 0; x < w; x++ {
+			bi := base + (x >> 3)
+			if bi >= len(xbm) {
+				break
+			}
+			shift := uint(x & 7) // LSB is leftmost pixel
+			if order == MSBFirst {
+				shift = uint(7 - (x & 7))
+			}
+			bit := (xbm[bi] >> shift) & 1
+			if bit == 1 {
+				setBit(outIdx)
+			}
+			outIdx++
+		}
+	}
+	return dst
+}
+
+// UnpackU32 reverses RepackBitsToU32: it returns the w*h pixels of the
+// packed data in row-major order, true where the bit is set. Pixel (x, y)
+// is bit i&31 of word i>>5 with i = y*w + x, which is the lookup the
+// generated xbm_bit() performs. Words missing from data read as unset.
+func UnpackU32(data []uint32, w, h int) []bool {
+	px := make([]bool, w*h)
+	for i := range px {
+		if i>>5 < len(data) {
+			px[i] = data[i>>5]>>uint(i&31)&1 == 1
+		}
+	}
+	return px
+}
+
+// Coverage returns the fraction (0..1) of the w*h pixels set in packed data.
+func Coverage(data []uint32, w, h int) float64 {
+	if w*h == 0 {
+		return 0
+	}
+	n := 0
+	for _, v := range data {
+		n += bits.OnesCount32(v)
+	}
+	return float64(n) / float64(w*h)
+}
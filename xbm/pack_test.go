@@ -0,0 +1,53 @@
+package xbm
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRepackBitOrder(t *testing.T) {
+	// Pixels 0, 1 and 5 of an 8×1 row are set: ##...#..
+	tests := []struct {
+		name  string
+		b     byte
+		order BitOrder
+	}{
+		{"lsb", 0x23, LSBFirst},
+		{"msb", 0xC4, MSBFirst},
+	}
+	for _, tt := range tests {
+		got := RepackBitsToU32([]byte{tt.b}, 8, 1, tt.order)
+		if want := []uint32{0x23}; !slices.Equal(got, want) {
+			t.Errorf("%s: RepackBitsToU32(0x%02X) = %#x, want %#x", tt.name, tt.b, got, want)
+		}
+	}
+
+	// The same byte read in the other order mirrors the row
+	if got := RepackBitsToU32([]byte{0x23}, 8, 1, MSBFirst); !slices.Equal(got, []uint32{0xC4}) {
+		t.Errorf("msb: RepackBitsToU32(0x23) = %#x, want [0xc4]", got)
+	}
+}
+
+func TestPackRoundTrip(t *testing.T) {
+	// 13 pixels wide, so rows end mid-byte and span word boundaries
+	const w, h = 13, 5
+	want := make([]bool, w*h)
+	for i := range want {
+		want[i] = (i*7+i/3)%5 < 2
+	}
+
+	// Pack the grid as XBM does: rows padded to whole bytes, LSB first
+	stride := (w + 7) / 8
+	src := make([]byte, stride*h)
+	for i, on := range want {
+		if on {
+			x, y := i%w, i/w
+			src[y*stride+x/8] |= 1 << uint(x%8)
+		}
+	}
+
+	got := UnpackU32(RepackBitsToU32(src, w, h, LSBFirst), w, h)
+	if !slices.Equal(got, want) {
+		t.Errorf("UnpackU32(RepackBitsToU32(...)) = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,103 @@
+package xbm
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// isPBM reports whether src starts with a plain (P1) or raw (P4) PBM magic.
+func isPBM(src []byte) bool {
+	return len(src) >= 3 && src[0] == 'P' && (src[1] == '1' || src[1] == '4') && isPBMSpace(src[2])
+}
+
+func isPBMSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+// ParsePBM reads a portable bitmap. Both the ASCII (P1) and binary (P4)
+// variants are supported; 1 (black) pixels become set bits.
+func ParsePBM(src []byte) (Image, error) {
+	if !isPBM(src) {
+		return Image{}, fmt.Errorf("pbm: missing P1/P4 magic")
+	}
+	binary := src[1] == '4'
+
+	pos := 2
+	var dims [2]int
+	for i := range dims {
+		tok, next := pbmToken(src, pos)
+		v, err := strconv.Atoi(tok)
+		if err != nil || v <= 0 {
+			return Image{}, fmt.Errorf("pbm: bad header value %q", tok)
+		}
+		dims[i], pos = v, next
+	}
+	w, h := dims[0], dims[1]
+
+	if binary {
+		// Exactly one whitespace byte separates the header from the raster.
+		pos++
+		rowBytes := (w + 7) / 8
+		// Compare by division first: rowBytes*h may overflow
+		if pos > len(src) || h > (len(src)-pos)/rowBytes {
+			return Image{}, fmt.Errorf("pbm: raster too short for %dx%d", w, h)
+		}
+		want := rowBytes * h
+		// P4 rows are byte-padded with the leftmost pixel in the MSB.
+		bits := append([]byte(nil), src[pos:pos+want]...)
+		return Image{Width: w, Height: h, Bits: bits, BitOrder: MSBFirst}, nil
+	}
+
+	// Every pixel takes at least one byte of text, so a size the rest of
+	// the file cannot hold is rejected before allocating for it
+	if h > len(src)-pos || w > (len(src)-pos)/h {
+		return Image{}, fmt.Errorf("pbm: raster too short for %dx%d", w, h)
+	}
+	img := newImage(w, h)
+	n := 0
+	for ; pos < len(src) && n < w*h; pos++ {
+		switch c := src[pos]; {
+		case c == '#':
+			for pos < len(src) && src[pos] != '\n' {
+				pos++
+			}
+		case c == '0' || c == '1':
+			if c == '1' {
+				img.set(n%w, n/w)
+			}
+			n++
+		case isPBMSpace(c):
+		default:
+			return Image{}, fmt.Errorf("pbm: unexpected %q in raster", c)
+		}
+	}
+	if n < w*h {
+		return Image{}, fmt.Errorf("pbm: raster too short: got %d pixels, want %d", n, w*h)
+	}
+	return img, nil
+}
+
+// pbmToken returns the next whitespace-delimited header token at or after
+// pos, skipping # comments, and the position just past it.
+func pbmToken(src []byte, pos int) (string, int) {
+	for pos < len(src) {
+		if src[pos] == '#' {
+			if i := bytes.IndexByte(src[pos:], '\n'); i >= 0 {
+				pos += i
+			} else {
+				pos = len(src)
+			}
+			continue
+		}
+		if !isPBMSpace(src[pos]) {
+			break
+		}
+		pos++
+	}
+	start := pos
+	for pos < len(src) && !isPBMSpace(src[pos]) && src[pos] != '#' {
+		pos++
+	}
+	return string(src[start:pos]), pos
+}
@@ -0,0 +1,38 @@
+package xbm
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParsePBM(t *testing.T) {
+	want := []string{"#..", ".##"}
+	for name, src := range map[string][]byte{
+		"P1": []byte("P1\n# comment\n3 2\n1 0 0\n0 1 1\n"),
+		"P4": []byte("P4\n3 2\n\x80\x60"),
+	} {
+		img, err := ParsePBM(src)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if got := rows(img); !slices.Equal(got, want) {
+			t.Errorf("%s: ParsePBM = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestParsePBMHugeSize(t *testing.T) {
+	// Sizes whose byte count overflows or exceeds the input must fail
+	// before anything is allocated for them
+	for _, src := range []string{
+		"P4\n4611686018427387904 4611686018427387904\n\x00",
+		"P4\n8 1000000000000\n\x00",
+		"P1\n100000000000 100000000000\n1 0\n",
+		"P1\n3 2\n1 0\n",
+	} {
+		if _, err := ParsePBM([]byte(src)); err == nil {
+			t.Errorf("ParsePBM(%q): got no error", src)
+		}
+	}
+}
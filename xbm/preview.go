@@ -0,0 +1,42 @@
+package xbm
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// WritePreview renders img as an RGBA PNG using fg for set bits and bg for
+// clear ones, each bitmap pixel drawn as a scale×scale block. It reads the
+// packed words exactly as the shader's xbm_bit() does, so the result can be
+// compared with in-engine output.
+func WritePreview(w io.Writer, img Image, fg, bg Color, scale int) error {
+	if scale < 1 {
+		scale = 1
+	}
+	data := img.Pack()
+	fgc := color.NRGBA{fg.R, fg.G, fg.B, fg.A}
+	bgc := color.NRGBA{bg.R, bg.G, bg.B, bg.A}
+
+	out := image.NewNRGBA(image.Rect(0, 0, img.Width*scale, img.Height*scale))
+	for y := 0; y < img.Height*scale; y++ {
+		for x := 0; x < img.Width*scale; x++ {
+			c := bgc
+			if xbmBit(data, img.Width, img.Height, x/scale, y/scale) {
+				c = fgc
+			}
+			out.SetNRGBA(x, y, c)
+		}
+	}
+	return png.Encode(w, out)
+}
+
+// xbmBit is the Go twin of the generated shader's xbm_bit().
+func xbmBit(data []uint32, w, h, x, y int) bool {
+	if x < 0 || y < 0 || x >= w || y >= h {
+		return false
+	}
+	idx := y*w + x
+	return (data[idx>>5]>>uint(idx&31))&1 == 1
+}
@@ -0,0 +1,42 @@
+package xbm
+
+import "bytes"
+
+// Info describes the structure of an input as Probe finds it.
+type Info struct {
+	Format        string // as reported by Format, or the forced format
+	Array         string // XBM: name of the bits array read, without _bits
+	Width, Height int
+	// Unit is the XBM element type the values were read as: "char",
+	// "short", or "mixed" when it was guessed per value and both sizes
+	// occurred. UnitFrom says where it came from: "option" (Unit was
+	// forced), "declaration" (the array's element type) or "values".
+	Unit, UnitFrom string
+	// Values is the count of numbers in an XBM bits array, and Bytes the
+	// bytes they make; other formats report only Bytes, the size of the
+	// decoded rows. Want is the byte count the width and height require.
+	Values int
+	Bytes  int
+	Want   int
+}
+
+// Probe reports the structure of src without requiring it to convert: a
+// file that DecodeWith rejects still yields whatever was found before the
+// problem, together with the error. For an XBM whose array length does
+// not match its #defines, for instance, Bytes and Want show by how much.
+func Probe(src []byte, opts DecodeOptions) (Info, error) {
+	format := opts.Format
+	if format == "" {
+		format = Format(src)
+	}
+	if format == "xbm" {
+		var info Info
+		_, err := parseXBM(bytes.NewReader(src), opts, &info)
+		return info, err
+	}
+	opts.Format = format
+	img, err := DecodeWith(src, opts)
+	info := Info{Format: format, Width: img.Width, Height: img.Height, Bytes: len(img.Bits)}
+	info.Want = ((img.Width + 7) / 8) * img.Height
+	return info, err
+}
@@ -0,0 +1,47 @@
+package xbm
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // register decoders for ParseRaster
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// isRaster reports whether src is an image format the standard library can
+// decode (PNG, GIF or JPEG).
+func isRaster(src []byte) bool {
+	_, _, err := image.DecodeConfig(bytes.NewReader(src))
+	return err == nil
+}
+
+// ParseRaster thresholds a PNG, GIF or JPEG into a bitmap. Pixels whose
+// luminance is below threshold become foreground bits; pixels that are
+// more than half transparent are always background. A threshold of 0
+// selects the default of 128.
+func ParseRaster(src []byte, threshold int) (Image, error) {
+	if threshold == 0 {
+		threshold = 128
+	}
+	m, _, err := image.Decode(bytes.NewReader(src))
+	if err != nil {
+		return Image{}, fmt.Errorf("raster: %w", err)
+	}
+	b := m.Bounds()
+	img := newImage(b.Dx(), b.Dy())
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			c := color.NRGBAModel.Convert(m.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+			if c.A < 0x80 {
+				continue
+			}
+			lum := (299*int(c.R) + 587*int(c.G) + 114*int(c.B)) / 1000
+			if lum < threshold {
+				img.set(x, y)
+			}
+		}
+	}
+	return img, nil
+}
@@ -0,0 +1,27 @@
+package xbm
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// RawMagic opens every file written by WriteRaw.
+const RawMagic = "XBMR"
+
+// WriteRaw writes img as a raw bit blob for embedding outside Godot: a
+// 12-byte header (RawMagic, then width and height as little-endian uint32)
+// followed by the packed words of Pack, each a little-endian uint32. The
+// words hold the pixels row-major with no row padding; pixel i = y*width+x
+// is bit i&31 of word i>>5, exactly as in the shader's DATA array.
+func WriteRaw(w io.Writer, img Image) error {
+	data := img.Pack()
+	buf := make([]byte, 0, 12+4*len(data))
+	buf = append(buf, RawMagic...)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(img.Width))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(img.Height))
+	for _, v := range data {
+		buf = binary.LittleEndian.AppendUint32(buf, v)
+	}
+	_, err := w.Write(buf)
+	return err
+}
@@ -0,0 +1,43 @@
+package xbm
+
+// Runs returns the run boundaries of the image: the row-major pixel indices
+// at which the value flips, starting from unset. Pixel i is set when an odd
+// number of boundaries are at or before i, which is what the rle-mode
+// xbm_bit() computes with a binary search.
+func (img Image) Runs() []uint32 {
+	var runs []uint32
+	on := false
+	for i, v := range UnpackU32(img.Pack(), img.Width, img.Height) {
+		if v != on {
+			runs = append(runs, uint32(i))
+			on = v
+		}
+	}
+	return runs
+}
+
+// rleHalf reports whether run boundaries of an image with n pixels fit in
+// 16 bits, so two can share one DATA word.
+func rleHalf(n int) bool {
+	return n <= 0x10000
+}
+
+// PackRuns packs run boundaries (see Runs) into the DATA words of rle
+// mode: two 16-bit boundaries per word (low half first) when the image has
+// at most 65536 pixels, otherwise one per word. There is always at least
+// one word, since GLSL has no empty arrays.
+func PackRuns(runs []uint32, w, h int) []uint32 {
+	var words []uint32
+	if rleHalf(w * h) {
+		words = make([]uint32, (len(runs)+1)/2)
+		for k, r := range runs {
+			words[k>>1] |= r << uint((k&1)*16)
+		}
+	} else {
+		words = append(words, runs...)
+	}
+	if len(words) == 0 {
+		words = []uint32{0}
+	}
+	return words
+}
@@ -0,0 +1,226 @@
+package xbm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// textReader yields the logical bytes of C source one at a time: line
+// endings normalized to LF and backslash-newline pairs removed, as
+// normalizeText and joinContinuations do for whole strings.
+type textReader struct {
+	r       *bufio.Reader
+	err     error  // first read error other than io.EOF
+	pending int    // raw byte read ahead by next, or -1
+	back    []byte // logical bytes pushed back by unread; last is next
+	digits  []byte // scratch for scanNumber
+}
+
+func newTextReader(r io.Reader) *textReader {
+	br := bufio.NewReaderSize(r, 64<<10)
+	if b, err := br.Peek(len(utf8BOM)); err == nil && string(b) == utf8BOM {
+		br.Discard(len(utf8BOM))
+	}
+	return &textReader{r: br, pending: -1}
+}
+
+// raw returns the next byte with CRLF and lone CR turned into LF.
+func (t *textReader) raw() (byte, bool) {
+	if t.pending >= 0 {
+		b := byte(t.pending)
+		t.pending = -1
+		return b, true
+	}
+	b, err := t.r.ReadByte()
+	if err != nil {
+		if err != io.EOF {
+			t.err = err
+		}
+		return 0, false
+	}
+	if b == '\r' {
+		if nb, err := t.r.ReadByte(); err == nil && nb != '\n' {
+			t.r.UnreadByte()
+		}
+		return '\n', true
+	}
+	return b, true
+}
+
+// next returns the next logical byte, skipping line continuations.
+func (t *textReader) next() (byte, bool) {
+	if n := len(t.back); n > 0 {
+		b := t.back[n-1]
+		t.back = t.back[:n-1]
+		return b, true
+	}
+	for {
+		b, ok := t.raw()
+		if !ok || b != '\\' {
+			return b, ok
+		}
+		nb, ok := t.raw()
+		if !ok {
+			return b, true
+		}
+		if nb != '\n' {
+			t.pending = int(nb)
+			return b, true
+		}
+	}
+}
+
+func (t *textReader) unread(b byte) {
+	t.back = append(t.back, b)
+}
+
+func (t *textReader) peek() (byte, bool) {
+	b, ok := t.next()
+	if ok {
+		t.unread(b)
+	}
+	return b, ok
+}
+
+// skipComment consumes a comment whose opening '/' has been read, if one
+// follows, and reports whether it did.
+func (t *textReader) skipComment() bool {
+	switch b, _ := t.peek(); b {
+	case '*':
+		t.next()
+		for {
+			c, ok := t.next()
+			if !ok {
+				return true
+			}
+			if c == '*' {
+				if d, _ := t.peek(); d == '/' {
+					t.next()
+					return true
+				}
+			}
+		}
+	case '/':
+		for {
+			c, ok := t.next()
+			if !ok || c == '\n' {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// scanBits reads the bits array initializer after its opening brace up to
+// the matching closing brace, calling emit with each number (hex 0x..,
+// binary 0b.. or decimal) outside comments. A number after a minus sign
+// is clamped to 0. It reports whether the closing brace was found; a
+// malformed number stops the scan with an error.
+func (t *textReader) scanBits(emit func(v int64)) (closed bool, err error) {
+	depth, neg := 1, false
+	for {
+		c, ok := t.next()
+		if !ok {
+			return false, nil
+		}
+		switch {
+		case c == '{':
+			depth++
+			neg = false
+		case c == '}':
+			if depth--; depth == 0 {
+				return true, nil
+			}
+			neg = false
+		case c == ',':
+			neg = false
+		case c == '-':
+			neg = true
+		case c == '/':
+			t.skipComment()
+		case isDigit(c):
+			v, err := t.scanNumber(c)
+			if err != nil {
+				return false, err
+			}
+			if neg {
+				v, neg = 0, false
+			}
+			emit(v)
+		}
+	}
+}
+
+// scanNumber reads a number starting with digit c: hex if it is 0 followed
+// by x and a hex digit, binary if 0 followed by b and a binary digit,
+// otherwise decimal.
+func (t *textReader) scanNumber(c byte) (int64, error) {
+	base, digits := 10, append(t.digits[:0], c)
+	if c == '0' {
+		if x, ok := t.next(); ok {
+			h, ok := t.next()
+			switch {
+			case ok && (x == 'x' || x == 'X') && isHexDigit(h):
+				base, digits = 16, append(digits[:0], h)
+			case ok && (x == 'b' || x == 'B') && (h == '0' || h == '1'):
+				base, digits = 2, append(digits[:0], h)
+			default:
+				if ok {
+					t.unread(h)
+				}
+				t.unread(x)
+			}
+		}
+	}
+	for {
+		d, ok := t.next()
+		if !ok {
+			break
+		}
+		// Decimal digits are taken in binary too, so 0b102 is an error
+		// rather than 0b10 followed by 2
+		if !isDigit(d) && !(base == 16 && isHexDigit(d)) {
+			t.unread(d)
+			break
+		}
+		digits = append(digits, d)
+	}
+	t.digits = digits
+
+	var v int64
+	for _, d := range digits {
+		n := int64(hexValue(d))
+		if n >= int64(base) || v > (math.MaxInt64-n)/int64(base) {
+			_, err := strconv.ParseInt(string(digits), base, 64)
+			tok := string(digits)
+			switch base {
+			case 16:
+				tok = "0x" + tok
+			case 2:
+				tok = "0b" + tok
+			}
+			return 0, fmt.Errorf("bad number %q: %w", tok, err)
+		}
+		v = v*int64(base) + n
+	}
+	return v, nil
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func hexValue(c byte) byte {
+	switch {
+	case isDigit(c):
+		return c - '0'
+	case c >= 'a':
+		return c - 'a' + 10
+	}
+	return c - 'A' + 10
+}
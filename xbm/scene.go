@@ -0,0 +1,133 @@
+package xbm
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// BuildScene returns a minimal text scene (.tscn) that shows the shader:
+// a ColorRect sized WIDTH×HEIGHT (times Scale) for canvas_item, or a
+// MeshInstance with a one unit wide QuadMesh for spatial. ref is the path
+// of either the shader or a ShaderMaterial .tres (by its extension) and is
+// written verbatim, like BuildMaterial's shaderPath. A shader reference gets
+// an embedded ShaderMaterial, which in the texture modes binds the bitmap
+// uniform to opts.Texture, if set. The per-node uniforms are preset from
+// opts as BuildMaterial does, on the embedded material, or on the node as
+// instance shader parameters when the shader declares them as instance
+// uniforms.
+func BuildScene(ref string, img Image, opts Options) (string, error) {
+	opts = opts.withDefaults()
+	switch opts.ShaderType {
+	case "canvas_item", "spatial":
+	default:
+		return "", fmt.Errorf("unknown shader type %q (want canvas_item or spatial)", opts.ShaderType)
+	}
+	ps, err := presets(opts)
+	if err != nil {
+		return "", err
+	}
+
+	w, h := img.Width, img.Height
+	if opts.Frames > 1 {
+		h /= opts.Frames
+	}
+	if opts.Scale > 1 {
+		w, h = w*opts.Scale, h*opts.Scale
+	}
+	if w == 0 || h == 0 {
+		return "", fmt.Errorf("cannot size a scene for a %dx%d bitmap", w, h)
+	}
+	material := strings.HasSuffix(ref, ".tres")
+	// A .tres material carries its own presets
+	var matPresets, nodePresets []preset
+	switch {
+	case opts.instanceUniforms():
+		nodePresets = ps
+	case !material:
+		matPresets = ps
+	}
+	spatial := opts.ShaderType == "spatial"
+	texture := !material && opts.textured() && opts.Texture != ""
+
+	steps := 2 // the referenced resource and the scene itself
+	if !material {
+		steps++
+	}
+	if texture {
+		steps++
+	}
+	if spatial {
+		steps++
+	}
+
+	var buf bytes.Buffer
+	if opts.Godot == 3 {
+		fmt.Fprintf(&buf, "[gd_scene load_steps=%d format=2]\n\n", steps)
+		if material {
+			fmt.Fprintf(&buf, "[ext_resource path=%q type=\"Material\" id=1]\n\n", ref)
+		} else {
+			fmt.Fprintf(&buf, "[ext_resource path=%q type=\"Shader\" id=1]\n", ref)
+			if texture {
+				fmt.Fprintf(&buf, "[ext_resource path=%q type=\"Texture\" id=2]\n", opts.Texture)
+			}
+			buf.WriteString("\n[sub_resource type=\"ShaderMaterial\" id=1]\n")
+			buf.WriteString("shader = ExtResource( 1 )\n")
+			if texture {
+				buf.WriteString("shader_param/bitmap = ExtResource( 2 )\n")
+			}
+			writePresets(&buf, "shader_param/", matPresets)
+			buf.WriteString("\n")
+		}
+		mat := "ExtResource( 1 )"
+		if !material {
+			mat = "SubResource( 1 )"
+		}
+		if spatial {
+			buf.WriteString("[sub_resource type=\"QuadMesh\" id=2]\n")
+			fmt.Fprintf(&buf, "size = Vector2( 1, %g )\n\n", float64(h)/float64(w))
+			buf.WriteString("[node name=\"Preview\" type=\"MeshInstance\"]\n")
+			buf.WriteString("mesh = SubResource( 2 )\n")
+			fmt.Fprintf(&buf, "material_override = %s\n", mat)
+		} else {
+			buf.WriteString("[node name=\"Preview\" type=\"ColorRect\"]\n")
+			fmt.Fprintf(&buf, "material = %s\n", mat)
+			fmt.Fprintf(&buf, "margin_right = %d.0\n", w)
+			fmt.Fprintf(&buf, "margin_bottom = %d.0\n", h)
+		}
+		return buf.String(), nil
+	}
+
+	fmt.Fprintf(&buf, "[gd_scene load_steps=%d format=3]\n\n", steps)
+	mat := `ExtResource("1")`
+	if material {
+		fmt.Fprintf(&buf, "[ext_resource type=\"Material\" path=%q id=\"1\"]\n\n", ref)
+	} else {
+		fmt.Fprintf(&buf, "[ext_resource type=\"Shader\" path=%q id=\"1\"]\n", ref)
+		if texture {
+			fmt.Fprintf(&buf, "[ext_resource type=\"Texture2D\" path=%q id=\"2\"]\n", opts.Texture)
+		}
+		buf.WriteString("\n[sub_resource type=\"ShaderMaterial\" id=\"ShaderMaterial_1\"]\n")
+		buf.WriteString("shader = ExtResource(\"1\")\n")
+		if texture {
+			buf.WriteString("shader_parameter/bitmap = ExtResource(\"2\")\n")
+		}
+		writePresets(&buf, "shader_parameter/", matPresets)
+		buf.WriteString("\n")
+		mat = `SubResource("ShaderMaterial_1")`
+	}
+	if spatial {
+		buf.WriteString("[sub_resource type=\"QuadMesh\" id=\"QuadMesh_1\"]\n")
+		fmt.Fprintf(&buf, "size = Vector2(1, %g)\n\n", float64(h)/float64(w))
+		buf.WriteString("[node name=\"Preview\" type=\"MeshInstance3D\"]\n")
+		buf.WriteString("mesh = SubResource(\"QuadMesh_1\")\n")
+		fmt.Fprintf(&buf, "material_override = %s\n", mat)
+	} else {
+		buf.WriteString("[node name=\"Preview\" type=\"ColorRect\"]\n")
+		fmt.Fprintf(&buf, "material = %s\n", mat)
+		fmt.Fprintf(&buf, "offset_right = %d.0\n", w)
+		fmt.Fprintf(&buf, "offset_bottom = %d.0\n", h)
+	}
+	writePresets(&buf, "instance_shader_parameters/", nodePresets)
+	return buf.String(), nil
+}
@@ -0,0 +1,61 @@
+package xbm
+
+import (
+	"image"
+	"image/png"
+	"io"
+	"math"
+)
+
+// SDFSpread is the distance, in bitmap pixels, covered by the full range of
+// an SDF texture: texels further than this from an edge saturate.
+const SDFSpread = 8
+
+// WriteSDFPNG encodes img as a signed distance field for sdf mode: an
+// 8-bit grayscale PNG of Width×Height texels, each holding the distance
+// from its centre to the nearest edge, 128 on the edge itself, brighter
+// inside set pixels and darker outside, reaching 0 and 255 at SDFSpread.
+// With tile set the bitmap is taken to repeat, so edges across the borders
+// count; otherwise the area outside it is ignored.
+//
+// The search is brute force over a (2*SDFSpread+1)² window per pixel,
+// which is plenty fast for bitmap-sized inputs.
+func WriteSDFPNG(w io.Writer, img Image, tile bool) error {
+	g := image.NewGray(image.Rect(0, 0, img.Width, img.Height))
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			d := edgeDistance(img, x, y, tile)
+			v := math.Round(127.5 + 127.5*d/SDFSpread)
+			g.Pix[g.PixOffset(x, y)] = uint8(math.Max(0, math.Min(255, v)))
+		}
+	}
+	return png.Encode(w, g)
+}
+
+// edgeDistance returns the signed distance from the centre of pixel (x, y)
+// to the boundary with the nearest pixel of the opposite value: positive
+// inside set pixels, negative outside, ±0.5 next to an edge and ±SDFSpread
+// when no edge is within reach.
+func edgeDistance(img Image, x, y int, tile bool) float64 {
+	on := img.At(x, y)
+	best := float64(SDFSpread) + 0.5
+	for dy := -SDFSpread; dy <= SDFSpread; dy++ {
+		for dx := -SDFSpread; dx <= SDFSpread; dx++ {
+			px, py := x+dx, y+dy
+			if tile {
+				px = ((px % img.Width) + img.Width) % img.Width
+				py = ((py % img.Height) + img.Height) % img.Height
+			} else if px < 0 || py < 0 || px >= img.Width || py >= img.Height {
+				continue
+			}
+			if img.At(px, py) != on {
+				best = math.Min(best, math.Hypot(float64(dx), float64(dy)))
+			}
+		}
+	}
+	d := math.Min(best-0.5, SDFSpread)
+	if !on {
+		d = -d
+	}
+	return d
+}
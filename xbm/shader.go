@@ -0,0 +1,1479 @@
+package xbm
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Options controls shader generation. The zero value is ready to use and
+// matches the CLI defaults: a canvas_item shader drawing set bits in opaque
+// black over transparent, pixel-locked to the screen, tiled. Set only the
+// fields you need.
+type Options struct {
+	// ShaderType is the Godot shader type: "canvas_item" (default) or
+	// "spatial".
+	ShaderType string
+	// FG and BG are the foreground/background colours, as hex or a colour
+	// name understood by ParseColor. Empty means opaque black for FG and
+	// transparent for BG.
+	FG string
+	BG string
+	// Mode selects how the bitmap is stored: "array" (default) embeds a
+	// const uint array, "texture" samples a companion image with one texel
+	// per pixel (see WritePNG) and "itexture" one with eight pixels packed
+	// into each texel (see WritePackedPNG). Both textures are bound to the
+	// "bitmap" uniform. "rle" embeds only the run boundaries (see Runs and
+	// PackRuns), found per pixel with a binary search; it is smaller than
+	// array mode for sparse bitmaps with few long runs. "sdf" samples a
+	// signed distance field (see WriteSDFPNG) with linear filtering and
+	// smoothsteps its edge, for anti-aliased edges at any scale; it always
+	// samples continuously (as Filter "smooth") and needs Godot 4.
+	// "visualshader" stores the bitmap as in array mode but returns a
+	// VisualShader resource (.tres) instead of shader code: the constants,
+	// uniforms and helpers sit in a global expression node and the body of
+	// fragment() in an expression node wired to the output. It needs
+	// Godot 4.1 or later.
+	Mode string
+	// UVSource selects the sampling coordinates: "screen" (default) locks
+	// the pattern to screen pixels, "uv" maps it onto the mesh UVs.
+	UVSource string
+	// Scale is the number of screen pixels (or UV cells) each bitmap pixel
+	// covers along each axis. Zero means 1.
+	Scale int
+	// Godot is the target engine major version: 4 (default) or 3.
+	// Godot 3 has no instance uniforms or sampler filter hints.
+	Godot int
+	// NoInvertUniform omits the runtime "invert" uniform, e.g. when the
+	// inversion has already been baked in with Invert.
+	NoInvertUniform bool
+	// Wrap controls what lies outside the bitmap: "tile" (default) repeats
+	// it, "clamp" stretches the edge pixels and "once" draws it a single
+	// time with background everywhere else.
+	Wrap string
+	// Center, with Wrap "once", moves the single copy of the bitmap from
+	// the top-left corner of the screen to the middle of the node's rect
+	// (the area UV spans). Requires the screen UV source and no Tile.
+	Center bool
+	// ColorSpace is how FG and BG are written: "srgb" keeps the hex values
+	// as-is, "linear" converts them to linear light. The default is srgb
+	// for canvas_item and linear for spatial, matching how Godot 4 treats
+	// canvas colours and 3D albedo, except that Godot 4 float colours with
+	// the source_color hint (see NoSourceColor) are written as srgb and
+	// converted by Godot.
+	ColorSpace string
+	// ColorFormat is how colour uniforms are stored: "float" (default) as
+	// vec4 in 0..1, or "int" as ivec4 holding the exact 0-255 RGBA bytes,
+	// converted (and, for linear, linearised) by xbm_color() in the shader.
+	// Int colours lose the inspector's colour picker and need Godot 4.
+	ColorFormat string
+	// NoSourceColor leaves out the source_color hint that Godot 4 float
+	// colour uniforms otherwise get, which makes the inspector show a
+	// colour picker and has Godot convert the sRGB values to linear where
+	// the shader needs it. Without it the values are plain floats, written
+	// according to ColorSpace. An explicit ColorSpace also drops the hint.
+	NoSourceColor bool
+	// PlainUniforms declares the colour, invert and other per-node
+	// uniforms as plain "uniform" instead of Godot 4's "instance
+	// uniform", so a ShaderMaterial can set them (see BuildMaterial);
+	// every node sharing the material then shows the same values. Godot 3
+	// has only plain uniforms.
+	PlainUniforms bool
+	// Frames splits a vertically stacked sprite sheet into this many
+	// frames of Height/Frames rows, animated over TIME. 0 or 1 disables
+	// animation.
+	Frames int
+	// FPS is the default of the "fps" uniform for animations (default 8).
+	FPS float64
+	// Atlas replaces the animation: the frame to draw is chosen with a
+	// "glyph_index" uniform instead of stepping through them over TIME.
+	// See Stack for building an atlas from same-sized images.
+	Atlas bool
+	// Channel picks what a spatial shader writes: "both" (default) sets
+	// ALBEDO and ALPHA from the colours, "albedo" only ALBEDO, and "alpha"
+	// only ALPHA, as the foreground weight (1 on foreground), so the bitmap
+	// can mask a material whose albedo comes from elsewhere. canvas_item
+	// shaders always write COLOR and ignore it.
+	Channel string
+	// RespectModulate makes a canvas_item shader multiply the foreground
+	// colour by the incoming COLOR (the vertex colour, which includes the
+	// node's Modulate) before mixing, so one shader can be tinted per node.
+	// Spatial shaders ignore it.
+	RespectModulate bool
+	// OverTexture makes a canvas_item shader a stencil over the node's own
+	// texture: background pixels show TEXTURE sampled at UV instead of BG,
+	// and no background uniform is declared. Spatial shaders ignore it.
+	OverTexture bool
+	// BGGradient, if set, replaces the flat BG with a vertical gradient
+	// from BGGradient[0] at the top of the bitmap to BGGradient[1] at its
+	// bottom, in the uniforms <bg>_top and <bg>_bottom. It repeats with
+	// every tile of the bitmap. Cannot be combined with OverTexture.
+	BGGradient [2]string
+	// Texture is the path of the companion texture of the texture,
+	// itexture and sdf modes, which BuildMaterial and BuildScene bind to
+	// the "bitmap" uniform. Like their shader path it is written verbatim.
+	// BuildShader ignores it, and without it the uniform is left unset.
+	Texture string
+	// Filter is "nearest" (default, pixel-perfect) or "smooth", which
+	// blends the four nearest bitmap pixels for softer edges when scaled.
+	Filter string
+	// Outline, if set, is the colour of a 1px outline drawn around
+	// foreground pixels (exposed as the "outline_color" uniform).
+	Outline string
+	// Comment holds text written as "// " comments at the very top of the
+	// shader, before everything else, e.g. a licence or attribution. Each
+	// entry may span several lines.
+	Comment []string
+	// Header holds extra lines for the comment block at the top of the
+	// shader, each written after "// ".
+	Header []string
+	// Include, if set, makes BuildShader emit a shader include
+	// (.gdshaderinc) instead of a full shader: only the constants, DATA and
+	// the bit lookup, renamed after Include (LOGO_WIDTH, LOGO_DATA,
+	// xbm_bit_logo for "logo") and wrapped in an include guard. The
+	// including shader supplies shader_type, uniforms and fragment().
+	// Requires array mode and Godot 4.
+	Include string
+	// Prefix, if set, is prepended to every generated constant and helper
+	// (DATA, WIDTH, HEIGHT, WORDS, ..., xbm_bit, xbm_wrap, ...) so the code
+	// can be pasted into a larger shader: "logo_" gives logo_DATA and
+	// logo_xbm_bit. Uniforms keep their names (see FGName). Cannot be
+	// combined with Include, which renames the symbols itself.
+	Prefix string
+	// Checksum, if set, is written as a "// source:" comment so stale
+	// shaders can be detected later (see Checksum and EmbeddedChecksum).
+	Checksum string
+	// Pack is the element type of the DATA array in array mode: "uint"
+	// (default), "int" (the same 32-bit words, signed), "u64" (64-bit
+	// words as uvec2 pairs, low word first, half the array length) or
+	// "uvec4" (four words per element, a quarter of the array length).
+	Pack string
+	// UniformDims declares WIDTH and HEIGHT as uniforms defaulting to the
+	// bitmap's size instead of consts, so the DATA of a bitmap with other
+	// dimensions can be swapped in without editing the declarations. The
+	// lookups read them at run time, which costs a little speed. Needs
+	// Godot 4 and cannot be combined with Include.
+	UniformDims bool
+	// Chunk, if positive, splits the DATA array of array mode into DATA0,
+	// DATA1, ... of at most Chunk words each (a multiple of the words per
+	// element with Pack "u64" or "uvec4"), and xbm_bit() picks the
+	// chunk by index, for drivers that limit the size of a single array. A
+	// DATA array that fits is left whole.
+	Chunk int
+	// Annotate writes a comment before each DATA element naming the pixels
+	// it holds, such as "// word 0: pixels (0,0)..(31,0)", for inspecting
+	// the packed data by hand. Needs array mode.
+	Annotate bool
+	// Tile, if non-zero, stretches the bitmap so it repeats Tile[0] × Tile[1]
+	// times across the screen (or the mesh with UVSource "uv") instead of
+	// being pixel-locked. It is the default of the "tile_repeat" uniform.
+	Tile [2]int
+	// ZeroFG makes bit 0 the foreground (fg_color) and bit 1 the
+	// background, for files that treat 0 as the drawn pixel. Unlike the
+	// invert uniform it is fixed at generation time.
+	ZeroFG bool
+	// DiscardBG makes background pixels discard the fragment instead of
+	// writing the background colour. With Outline, outline pixels are kept.
+	DiscardBG bool
+	// Mask, if set, is a second bitmap of the same size, like the mask an
+	// X11 cursor ships with: pixels whose mask bit is clear are drawn
+	// transparent (or discarded with DiscardBG) whatever the image holds.
+	// It is embedded as MASK, packed like DATA, and read by xbm_mask().
+	// Needs array mode, the nearest filter and, for spatial shaders, an
+	// alpha channel.
+	Mask *Image
+	// CornerRadius, if positive, rounds the corners of every bitmap tile:
+	// pixels outside a rounded rectangle of that radius (in bitmap pixels,
+	// at most half the tile) are drawn as background, or discarded with
+	// DiscardBG.
+	CornerRadius int
+	// EmitRotation adds a "rotation" uniform (radians) that turns the
+	// pattern around the centre of the tile.
+	EmitRotation bool
+	// EmitBlink adds a "blink_hz" uniform: when above 0 the foreground's
+	// alpha pulses smoothly between 0 and full that many times a second.
+	// At its default of 0 the shader draws as without it.
+	EmitBlink bool
+	// EmitHotspot adds "const ivec2 HOTSPOT" from the image's hotspot.
+	EmitHotspot bool
+	// FGName, BGName and InvertName override the uniform identifiers
+	// (default fg_color, bg_color and invert).
+	FGName     string
+	BGName     string
+	InvertName string
+}
+
+// withDefaults fills in the zero fields whose default is not handled where
+// they are used.
+func (o Options) withDefaults() Options {
+	if o.ShaderType == "" {
+		o.ShaderType = "canvas_item"
+	}
+	if o.FG == "" {
+		o.FG = "#000000FF"
+	}
+	if o.BG == "" {
+		o.BG = "#00000000"
+	}
+	return o
+}
+
+func (o Options) fps() float64 {
+	if o.FPS == 0 {
+		return 8
+	}
+	return o.FPS
+}
+
+// linear reports whether colours should be emitted in linear light.
+func (o Options) linear() bool {
+	if o.ColorSpace == "" {
+		return o.ShaderType == "spatial" && !o.sourceColor()
+	}
+	return o.ColorSpace == "linear"
+}
+
+// instanceUniforms reports whether the per-node uniforms are declared as
+// instance uniforms, which only a node, not a material, can set.
+func (o Options) instanceUniforms() bool {
+	return o.Godot != 3 && !o.PlainUniforms
+}
+
+// sourceColor reports whether the colour uniforms carry Godot 4's
+// source_color hint, leaving sRGB-to-linear conversion to Godot.
+func (o Options) sourceColor() bool {
+	return o.Godot != 3 && o.ColorFormat != "int" && o.ColorSpace == "" && !o.NoSourceColor
+}
+
+// overTexture reports whether background pixels show the node's texture
+// (OverTexture, which only canvas_item shaders honour).
+func (o Options) overTexture() bool {
+	return o.OverTexture && o.ShaderType == "canvas_item"
+}
+
+// gradient reports whether the background is BGGradient rather than BG.
+func (o Options) gradient() bool {
+	return o.BGGradient != [2]string{}
+}
+
+// coverage is the foreground share of img under opts.ZeroFG.
+func (o Options) coverage(img Image) float64 {
+	if o.ZeroFG {
+		return 1 - img.Coverage()
+	}
+	return img.Coverage()
+}
+
+// textured reports whether the bitmap lives in a companion texture bound
+// to the "bitmap" uniform rather than in the shader.
+func (o Options) textured() bool {
+	return o.Mode == "texture" || o.Mode == "itexture" || o.Mode == "sdf"
+}
+
+// ColorValue formats c as BuildShader writes the default of a colour
+// uniform: a vec4 or, with ColorFormat "int", an ivec4 literal, in the
+// colour space ColorSpace and ShaderType select.
+func (o Options) ColorValue(c Color) string {
+	if o.ColorFormat == "int" {
+		return c.ivec4()
+	}
+	return c.vec4(o.linear())
+}
+
+// colorRef returns the GLSL expression reading colour uniform name as a
+// vec4 in 0..1.
+func (o Options) colorRef(name string) string {
+	if o.ColorFormat == "int" {
+		return "xbm_color(" + name + ")"
+	}
+	return name
+}
+
+// uniformNames are the resolved identifiers of the generated uniforms.
+type uniformNames struct {
+	fg, bg, invert string
+}
+
+func (o Options) names() uniformNames {
+	n := uniformNames{fg: "fg_color", bg: "bg_color", invert: "invert"}
+	if o.FGName != "" {
+		n.fg = o.FGName
+	}
+	if o.BGName != "" {
+		n.bg = o.BGName
+	}
+	if o.InvertName != "" {
+		n.invert = o.InvertName
+	}
+	return n
+}
+
+func (n uniformNames) check() error {
+	for _, name := range []string{n.fg, n.bg, n.invert} {
+		if err := checkIdent(name); err != nil {
+			return fmt.Errorf("uniform name: %w", err)
+		}
+	}
+	if n.fg == n.bg || n.fg == n.invert || n.bg == n.invert {
+		return fmt.Errorf("uniform names must be distinct, got %s, %s and %s", n.fg, n.bg, n.invert)
+	}
+	return nil
+}
+
+// BuildShader generates a Godot shader that tiles img across the screen.
+func BuildShader(img Image, opts Options) (string, error) {
+	var b strings.Builder
+	if err := BuildShaderTo(&b, img, opts); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// BuildShaderTo is BuildShader writing the shader to w. In the plain
+// shader modes the code, DATA array included, is streamed through a small
+// buffer rather than built in memory first. Invalid options are reported
+// before anything is written.
+func BuildShaderTo(w io.Writer, img Image, opts Options) error {
+	if img.Width <= 0 || img.Height <= 0 {
+		return fmt.Errorf("%w: %dx%d", ErrZeroSize, img.Width, img.Height)
+	}
+	opts = opts.withDefaults()
+	fg, err := ParseColor(opts.FG)
+	if err != nil {
+		return err
+	}
+	bg, err := ParseColor(opts.BG)
+	if err != nil {
+		return err
+	}
+	if opts.gradient() {
+		for _, c := range opts.BGGradient {
+			if _, err := ParseColor(c); err != nil {
+				return fmt.Errorf("bg gradient: %w", err)
+			}
+		}
+	}
+	switch opts.ShaderType {
+	case "canvas_item", "spatial":
+	default:
+		return fmt.Errorf("unknown shader type %q (want canvas_item or spatial)", opts.ShaderType)
+	}
+	switch opts.Mode {
+	case "", "array", "texture", "itexture", "rle":
+	case "sdf":
+		switch {
+		case opts.Godot == 3:
+			return fmt.Errorf("sdf mode needs Godot 4")
+		case opts.Frames > 1:
+			return fmt.Errorf("sdf mode does not support frames")
+		case opts.Outline != "":
+			return fmt.Errorf("outline is not supported in sdf mode")
+		}
+		opts.Filter = "smooth" // the distance field is sampled continuously
+	case "visualshader":
+		if opts.Godot == 3 {
+			return fmt.Errorf("visualshader mode needs Godot 4")
+		}
+	default:
+		return fmt.Errorf("unknown mode %q (want array, texture, itexture, rle, sdf or visualshader)", opts.Mode)
+	}
+	switch opts.UVSource {
+	case "", "screen", "uv":
+	default:
+		return fmt.Errorf("unknown uv source %q (want uv or screen)", opts.UVSource)
+	}
+	switch opts.Godot {
+	case 0, 3, 4:
+	default:
+		return fmt.Errorf("unsupported Godot version %d (want 3 or 4)", opts.Godot)
+	}
+	switch opts.Wrap {
+	case "", "tile", "clamp", "once":
+	default:
+		return fmt.Errorf("unknown wrap %q (want tile, clamp or once)", opts.Wrap)
+	}
+	switch opts.Pack {
+	case "", "uint", "int", "u64", "uvec4":
+	default:
+		return fmt.Errorf("unknown pack %q (want uint, int, u64 or uvec4)", opts.Pack)
+	}
+	if opts.overTexture() && opts.gradient() {
+		return fmt.Errorf("over texture cannot be combined with a background gradient")
+	}
+	if opts.CornerRadius < 0 {
+		return fmt.Errorf("corner radius must not be negative, got %d", opts.CornerRadius)
+	}
+	if opts.Mask != nil {
+		switch {
+		case opts.Mask.Width != img.Width || opts.Mask.Height != img.Height:
+			return fmt.Errorf("mask is %dx%d but the image is %dx%d", opts.Mask.Width, opts.Mask.Height, img.Width, img.Height)
+		case opts.Mode != "" && opts.Mode != "array" && opts.Mode != "visualshader":
+			return fmt.Errorf("mask needs array mode, not %q", opts.Mode)
+		case opts.Filter == "smooth":
+			return fmt.Errorf("mask is not supported with the smooth filter")
+		case opts.Include != "":
+			return fmt.Errorf("mask cannot be combined with include")
+		case opts.ShaderType == "spatial" && opts.Channel == "albedo":
+			return fmt.Errorf("mask needs an alpha channel, not the albedo channel")
+		}
+	}
+	if opts.overTexture() && opts.DiscardBG {
+		return fmt.Errorf("over texture cannot be combined with discarding the background")
+	}
+	if opts.UniformDims {
+		switch {
+		case opts.Godot == 3:
+			return fmt.Errorf("uniform dimensions need Godot 4")
+		case opts.Include != "":
+			return fmt.Errorf("uniform dimensions cannot be combined with include")
+		}
+	}
+	if opts.Chunk != 0 {
+		switch {
+		case opts.Chunk < 0:
+			return fmt.Errorf("chunk must be positive, got %d", opts.Chunk)
+		case opts.Mode != "" && opts.Mode != "array" && opts.Mode != "visualshader":
+			return fmt.Errorf("chunk needs array mode, not %q", opts.Mode)
+		case opts.Chunk%opts.packWords() != 0:
+			return fmt.Errorf("chunk must be a multiple of %d words with %s packing, got %d", opts.packWords(), opts.Pack, opts.Chunk)
+		case opts.Include != "":
+			return fmt.Errorf("chunk cannot be combined with include")
+		}
+	}
+	if opts.Annotate && opts.Mode != "" && opts.Mode != "array" && opts.Mode != "visualshader" {
+		return fmt.Errorf("annotate needs array mode, not %q", opts.Mode)
+	}
+	if opts.Mode == "rle" && opts.Pack != "" && opts.Pack != "uint" {
+		return fmt.Errorf("rle mode stores uint words, not %s", opts.Pack)
+	}
+	switch opts.Filter {
+	case "", "nearest", "smooth":
+	default:
+		return fmt.Errorf("unknown filter %q (want nearest or smooth)", opts.Filter)
+	}
+	switch opts.Channel {
+	case "", "both", "albedo", "alpha":
+	default:
+		return fmt.Errorf("unknown channel %q (want both, albedo or alpha)", opts.Channel)
+	}
+	if opts.Channel == "alpha" && opts.Outline != "" && opts.ShaderType == "spatial" {
+		return fmt.Errorf("outline is not supported with the alpha channel")
+	}
+	switch opts.ColorFormat {
+	case "", "float":
+	case "int":
+		if opts.Godot == 3 {
+			return fmt.Errorf("int colours need Godot 4")
+		}
+	default:
+		return fmt.Errorf("unknown colour format %q (want float or int)", opts.ColorFormat)
+	}
+	switch opts.ColorSpace {
+	case "", "srgb", "linear":
+	default:
+		return fmt.Errorf("unknown colour space %q (want srgb or linear)", opts.ColorSpace)
+	}
+	if opts.Scale < 0 {
+		return fmt.Errorf("scale must be positive, got %d", opts.Scale)
+	}
+	if strings.ContainsAny(opts.Checksum, " \t\r\n") {
+		return fmt.Errorf("checksum %q contains whitespace", opts.Checksum)
+	}
+	for _, line := range opts.Header {
+		if strings.ContainsAny(line, "\r\n") {
+			return fmt.Errorf("header line %q contains a line break", line)
+		}
+	}
+	if opts.Center {
+		switch {
+		case opts.Wrap != "once":
+			return fmt.Errorf("center needs the once wrap")
+		case opts.UVSource == "uv" || opts.Tile != [2]int{}:
+			return fmt.Errorf("center only works on screen coordinates, without tile repeat or uv")
+		}
+	}
+	if opts.Tile != [2]int{} {
+		if opts.Tile[0] <= 0 || opts.Tile[1] <= 0 {
+			return fmt.Errorf("tile repeat must be positive, got %d,%d", opts.Tile[0], opts.Tile[1])
+		}
+		if opts.Scale > 1 {
+			return fmt.Errorf("tile repeat and scale cannot be combined")
+		}
+	}
+	if opts.Outline != "" {
+		if _, err := ParseColor(opts.Outline); err != nil {
+			return fmt.Errorf("outline: %w", err)
+		}
+		if opts.Filter == "smooth" {
+			return fmt.Errorf("outline is not supported with the smooth filter")
+		}
+	}
+	if opts.Frames < 0 {
+		return fmt.Errorf("frames must be positive, got %d", opts.Frames)
+	}
+	if opts.Frames > 1 && img.Height%opts.Frames != 0 {
+		return fmt.Errorf("height %d is not divisible into %d frames", img.Height, opts.Frames)
+	}
+	if err := opts.names().check(); err != nil {
+		return err
+	}
+	if opts.Prefix != "" {
+		switch {
+		case !reIdent.MatchString(opts.Prefix):
+			return fmt.Errorf("prefix %q does not start a valid identifier", opts.Prefix)
+		case strings.HasPrefix(opts.Prefix, "gl_"):
+			return fmt.Errorf("prefix %q uses the reserved gl_ prefix", opts.Prefix)
+		case opts.Include != "":
+			return fmt.Errorf("prefix cannot be combined with include")
+		}
+	}
+	if opts.Include != "" {
+		switch {
+		case !reIdent.MatchString(opts.Include):
+			return fmt.Errorf("include name %q is not a valid identifier", opts.Include)
+		case opts.Mode != "" && opts.Mode != "array":
+			return fmt.Errorf("include mode needs array mode, not %q", opts.Mode)
+		case opts.Godot == 3:
+			return fmt.Errorf("Godot 3 shaders cannot use includes")
+		}
+		_, err := io.WriteString(w, buildInclude(opts, img))
+		return err
+	}
+	if opts.Mode == "visualshader" {
+		_, err := io.WriteString(w, buildVisualShader(opts, img, opts.ColorValue(fg), opts.ColorValue(bg)))
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	writeShader(bw, opts, img, opts.ColorValue(fg), opts.ColorValue(bg))
+	return bw.Flush()
+}
+
+// buildShader is writeShader into a string.
+func buildShader(opts Options, img Image, fg, bg string) string {
+	var buf bytes.Buffer
+	writeShader(&buf, opts, img, fg, bg)
+	return buf.String()
+}
+
+// shaderWriter is what the shader code is written to: a bytes.Buffer, or
+// a bufio.Writer when streaming, which keeps the first error for Flush.
+type shaderWriter interface {
+	io.Writer
+	io.StringWriter
+}
+
+// writeShader writes the shader for BuildShaderTo, which has validated
+// opts and resolved the colours to fg and bg.
+func writeShader(w shaderWriter, opts Options, img Image, fg, bg string) {
+	texture := opts.textured()
+
+	var data []uint32
+	var runs []uint32
+	switch {
+	case opts.Mode == "rle":
+		runs = img.Runs()
+		data = PackRuns(runs, img.Width, img.Height)
+	case !texture:
+		data = img.Pack()
+	}
+
+	writeComment(w, opts)
+	fmt.Fprintf(w, "// coverage: %.1f%% foreground\n", 100*opts.coverage(img))
+	if opts.Mode == "rle" {
+		fmt.Fprintf(w, "// rle: %d run boundaries in %d words (array mode: %d words)\n",
+			len(runs), len(data), len(img.Pack()))
+	}
+	if opts.Checksum != "" {
+		fmt.Fprintf(w, "// source: %s\n", opts.Checksum)
+	}
+	for _, line := range opts.Header {
+		fmt.Fprintf(w, "// %s\n", line)
+	}
+	fmt.Fprintf(w, "shader_type %s;\n\n", opts.ShaderType)
+
+	// Prefix renames the generated symbols in the code, but not in the
+	// comment block above, so the code is collected first
+	out := w
+	var code bytes.Buffer
+	if opts.Prefix != "" {
+		out = &code
+	}
+
+	writeConstants(out, opts, img, data)
+	out.WriteString("\n")
+
+	// Uniforms (Godot 3 has no per-instance uniforms)
+	uniform, colorHint := "uniform", ""
+	if opts.instanceUniforms() {
+		uniform = "instance uniform"
+	}
+	switch {
+	case opts.Godot == 3:
+		colorHint = " : hint_color"
+	case opts.sourceColor():
+		colorHint = " : source_color"
+	}
+	n := opts.names()
+	if opts.ZeroFG {
+		out.WriteString("// Foreground = bit 0; Background = bit 1 (XBM 'black')\n")
+	} else {
+		out.WriteString("// Foreground = bit 1 (XBM 'black'); Background = bit 0\n")
+	}
+	colorType := "vec4"
+	if opts.ColorFormat == "int" {
+		out.WriteString("// Colours are 0-255 RGBA bytes, read through xbm_color()\n")
+		colorType, colorHint = "ivec4", ""
+	}
+	fmt.Fprintf(out, "%s %s %s%s = %s;\n", uniform, colorType, n.fg, colorHint, fg)
+	switch {
+	case opts.overTexture():
+		out.WriteString("// Background pixels show the node's texture\n")
+	case opts.gradient():
+		top, _ := ParseColor(opts.BGGradient[0]) // validated by BuildShader
+		bottom, _ := ParseColor(opts.BGGradient[1])
+		out.WriteString("// Background: vertical gradient from the top to the bottom of the bitmap\n")
+		fmt.Fprintf(out, "%s %s %s_top%s = %s;\n", uniform, colorType, n.bg, colorHint, opts.ColorValue(top))
+		fmt.Fprintf(out, "%s %s %s_bottom%s = %s;\n", uniform, colorType, n.bg, colorHint, opts.ColorValue(bottom))
+	default:
+		fmt.Fprintf(out, "%s %s %s%s = %s;\n", uniform, colorType, n.bg, colorHint, bg)
+	}
+	if !opts.NoInvertUniform {
+		fmt.Fprintf(out, "%s bool %s = false;\n", uniform, n.invert)
+	}
+	if opts.Outline != "" {
+		oc, _ := ParseColor(opts.Outline) // validated by BuildShader
+		fmt.Fprintf(out, "%s %s outline_color%s = %s;\n", uniform, colorType, colorHint, opts.ColorValue(oc))
+	}
+	if opts.EmitRotation {
+		fmt.Fprintf(out, "%s float rotation = 0.0;\n", uniform)
+	}
+	if opts.EmitBlink {
+		fmt.Fprintf(out, "%s float blink_hz = 0.0;\n", uniform)
+	}
+	switch {
+	case opts.Frames > 1 && opts.Atlas:
+		fmt.Fprintf(out, "%s int glyph_index = 0;\n", uniform)
+	case opts.Frames > 1:
+		fmt.Fprintf(out, "uniform float fps = %s;\n", glslFloat(opts.fps()))
+	}
+	if opts.Tile != [2]int{} {
+		fmt.Fprintf(out, "%s ivec2 tile_repeat = ivec2(%d, %d);\n", uniform, opts.Tile[0], opts.Tile[1])
+	}
+	out.WriteString("\n")
+
+	switch {
+	case !texture:
+		writeData(out, opts, data, img.Width, img.Height)
+	case opts.Mode == "itexture" && opts.Godot == 3:
+		out.WriteString("uniform usampler2D bitmap;\n\n")
+	case opts.Mode == "itexture":
+		out.WriteString("uniform usampler2D bitmap : filter_nearest;\n\n")
+	case opts.Godot == 3:
+		out.WriteString("uniform sampler2D bitmap;\n\n")
+	case opts.Mode == "sdf" && (opts.Wrap == "" || opts.Wrap == "tile"):
+		out.WriteString("uniform sampler2D bitmap : filter_linear, repeat_enable;\n\n")
+	case opts.Mode == "sdf":
+		out.WriteString("uniform sampler2D bitmap : filter_linear;\n\n")
+	default:
+		out.WriteString("uniform sampler2D bitmap : filter_nearest;\n\n")
+	}
+
+	// Bit lookup (the distance field is only ever sampled smoothly)
+	if opts.Mode == "sdf" {
+		writeSDFLookup(out, opts)
+	} else {
+		writeBitLookup(out, opts, img)
+		if opts.Mask != nil {
+			writeMask(out, opts, *opts.Mask)
+		}
+		if opts.Filter == "smooth" || opts.Outline != "" {
+			writeWrap(out, opts)
+		}
+		if opts.Filter == "smooth" {
+			writeSmoothLookup(out, opts)
+		}
+	}
+	if opts.EmitRotation {
+		writeRotate(out, opts)
+	}
+	if opts.ColorFormat == "int" {
+		writeColorLookup(out, opts)
+	}
+
+	writeFragment(out, opts)
+	if opts.Prefix != "" {
+		w.WriteString(reGeneratedSym.ReplaceAllString(code.String(), opts.Prefix+"$1"))
+	}
+}
+
+// reGeneratedSym matches the constants and helper functions the generated
+// shader declares, which Prefix renames.
+var reGeneratedSym = regexp.MustCompile(`\b(WIDTH|HEIGHT|FRAMES|WORDS|RUNS|SCALE|CHUNK|DATA[0-9]*|MASK[0-9]*|HOTSPOT|xbm_[a-z]+)\b`)
+
+// writeComment emits opts.Comment as "// " lines, one per line of text.
+func writeComment(buf shaderWriter, opts Options) {
+	for _, text := range opts.Comment {
+		for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+			if line == "" {
+				buf.WriteString("//\n")
+			} else {
+				fmt.Fprintf(buf, "// %s\n", line)
+			}
+		}
+	}
+}
+
+// writeConstants emits WIDTH, HEIGHT and the other constants describing
+// the bitmap. data is the DATA array (nil in texture modes).
+func writeConstants(buf shaderWriter, opts Options, img Image, data []uint32) {
+	texture := opts.textured()
+	dim := "const uint"
+	if opts.UniformDims {
+		dim = "uniform uint"
+	}
+	fmt.Fprintf(buf, "%s WIDTH = %du;\n", dim, img.Width)
+	if opts.Frames > 1 {
+		fmt.Fprintf(buf, "%s HEIGHT = %du; // per frame\n", dim, img.Height/opts.Frames)
+		fmt.Fprintf(buf, "const uint FRAMES = %du;\n", opts.Frames)
+	} else {
+		fmt.Fprintf(buf, "%s HEIGHT = %du;\n", dim, img.Height)
+	}
+	if !texture {
+		words := opts.elements(len(data))
+		fmt.Fprintf(buf, "const uint WORDS = %du;\n", words)
+		if size := opts.chunkSize(words); size > 0 {
+			fmt.Fprintf(buf, "const int CHUNK = %d; // DATA0..DATA%d\n", size, (words-1)/size)
+		}
+	}
+	if opts.Mode == "rle" {
+		fmt.Fprintf(buf, "const int RUNS = %d;\n", len(img.Runs()))
+	}
+	if opts.Scale > 1 {
+		fmt.Fprintf(buf, "const uint SCALE = %du;\n", opts.Scale)
+	}
+	if opts.EmitHotspot {
+		fmt.Fprintf(buf, "const ivec2 HOTSPOT = ivec2(%d, %d);\n", img.XHot, img.YHot)
+	}
+}
+
+// reIncludeSym matches the generated symbols that include mode renames.
+var reIncludeSym = regexp.MustCompile(`\b(WIDTH|HEIGHT|FRAMES|WORDS|DATA|HOTSPOT|xbm_bit)\b`)
+
+// buildInclude emits the include-mode variant of the shader: the constants,
+// DATA and xbm_bit() renamed after opts.Include, inside an include guard.
+func buildInclude(opts Options, img Image) string {
+	data := img.Pack()
+	opts.Scale = 0 // scaling belongs to the including shader
+
+	var body bytes.Buffer
+	writeConstants(&body, opts, img, data)
+	body.WriteString("\n")
+	writeData(&body, opts, data, img.Width, img.Height)
+	writeBitLookup(&body, opts, img)
+
+	prefix := strings.ToUpper(opts.Include) + "_"
+	code := reIncludeSym.ReplaceAllStringFunc(body.String(), func(sym string) string {
+		if sym == "xbm_bit" {
+			return "xbm_bit_" + opts.Include
+		}
+		return prefix + sym
+	})
+
+	var buf bytes.Buffer
+	writeComment(&buf, opts)
+	fmt.Fprintf(&buf, "// coverage: %.1f%% foreground\n", 100*opts.coverage(img))
+	if opts.Checksum != "" {
+		fmt.Fprintf(&buf, "// source: %s\n", opts.Checksum)
+	}
+	for _, line := range opts.Header {
+		fmt.Fprintf(&buf, "// %s\n", line)
+	}
+	guard := "XBM_" + strings.ToUpper(opts.Include) + "_INC"
+	fmt.Fprintf(&buf, "#ifndef %s\n#define %s\n\n", guard, guard)
+	buf.WriteString(code)
+	buf.WriteString("#endif\n")
+	return buf.String()
+}
+
+// glslFloat formats v as a GLSL float literal (always with a decimal point).
+func glslFloat(v float64) string {
+	s := strconv.FormatFloat(v, 'f', -1, 64)
+	if !strings.ContainsAny(s, ".eE") {
+		s += ".0"
+	}
+	return s
+}
+
+// writeData emits the DATA array in the element type chosen by opts.Pack.
+func writeData(buf shaderWriter, opts Options, data []uint32, w, h int) {
+	// Elements are formatted one at a time, so a large array is never
+	// held as text in full
+	n, elem := len(data), func(i int) string { return fmt.Sprintf("0x%08Xu", data[i]) }
+	switch opts.Pack {
+	case "int":
+		elem = func(i int) string { return glslInt(int32(data[i])) }
+	case "u64", "uvec4":
+		n = opts.elements(len(data))
+		elem = func(i int) string {
+			q := make([]string, opts.packWords())
+			for j := range q {
+				q[j] = "0u"
+				if k := len(q)*i + j; k < len(data) {
+					q[j] = fmt.Sprintf("0x%08Xu", data[k])
+				}
+			}
+			return opts.elemType() + "(" + strings.Join(q, ", ") + ")"
+		}
+	}
+
+	typ := opts.elemType()
+	per := opts.packWords()
+	array := func(from, to int) {
+		for i := from; i < to; i++ {
+			sep := ",\n    "
+			if i == from {
+				sep = "    "
+			}
+			buf.WriteString(sep)
+			if opts.Annotate {
+				writeWordNote(buf, i*per, min((i+1)*per, len(data)), w, h)
+			}
+			buf.WriteString(elem(i))
+		}
+		buf.WriteString("\n);\n\n")
+	}
+	size := opts.chunkSize(n)
+	if size == 0 {
+		fmt.Fprintf(buf, "const %s DATA[WORDS] = %s[](\n", typ, typ)
+		array(0, n)
+		return
+	}
+	for k := 0; k*size < n; k++ {
+		to := min((k+1)*size, n)
+		fmt.Fprintf(buf, "const %s DATA%d[%d] = %s[](\n", typ, k, to-k*size, typ)
+		array(k*size, to)
+	}
+}
+
+// writeWordNote emits the comment Annotate puts before the DATA element
+// holding words from..to-1: the first and last pixel they cover, in
+// row-major order across the w×h bitmap.
+func writeWordNote(buf shaderWriter, from, to, w, h int) {
+	words := fmt.Sprintf("word %d", from)
+	if to-from > 1 {
+		words = fmt.Sprintf("words %d-%d", from, to-1)
+	}
+	first, last := 32*from, min(32*to, w*h)-1
+	fmt.Fprintf(buf, "// %s: pixels (%d,%d)..(%d,%d)\n    ", words, first%w, first/w, last%w, last/w)
+}
+
+// packWords is the number of 32-bit words in each DATA element.
+func (o Options) packWords() int {
+	switch o.Pack {
+	case "u64":
+		return 2
+	case "uvec4":
+		return 4
+	}
+	return 1
+}
+
+// elements is the length of the DATA array holding words 32-bit words;
+// a partly filled last element is zero-padded.
+func (o Options) elements(words int) int {
+	per := o.packWords()
+	return (words + per - 1) / per
+}
+
+// elemType is the GLSL type of the DATA elements.
+func (o Options) elemType() string {
+	switch o.Pack {
+	case "", "uint":
+		return "uint"
+	case "u64":
+		return "uvec2"
+	}
+	return o.Pack
+}
+
+// chunkSize is the number of DATA elements in each chunk array for
+// Chunk, or 0 when all n elements stay in one DATA array.
+func (o Options) chunkSize(n int) int {
+	size := o.Chunk / o.packWords()
+	if size <= 0 || n <= size {
+		return 0
+	}
+	return size
+}
+
+// writeChunkRead emits code that declares typ w and reads the DATA
+// element at index into it, from whichever of the chunk arrays of size
+// elements holds it (see Options.Chunk).
+func writeChunkRead(buf shaderWriter, typ, index string, n, size int) {
+	fmt.Fprintf(buf, "    int i = %s;\n", index)
+	fmt.Fprintf(buf, "    %s w;\n", typ)
+	last := (n - 1) / size
+	for k := 0; k <= last; k++ {
+		off := ""
+		switch k {
+		case 0:
+		case 1:
+			off = " - CHUNK"
+		default:
+			off = fmt.Sprintf(" - %d * CHUNK", k)
+		}
+		switch {
+		case k == 0:
+			buf.WriteString("    if (i < CHUNK) w = DATA0[i];\n")
+		case k < last:
+			fmt.Fprintf(buf, "    else if (i < %d * CHUNK) w = DATA%d[i%s];\n", k+1, k, off)
+		default:
+			fmt.Fprintf(buf, "    else w = DATA%d[i%s];\n", k, off)
+		}
+	}
+}
+
+// glslInt formats v as a GLSL int literal. The most negative value has no
+// literal form (2147483648 overflows before negation), so it is spelled as
+// an expression.
+func glslInt(v int32) string {
+	if v == math.MinInt32 {
+		return "(-2147483647 - 1)"
+	}
+	return strconv.Itoa(int(v))
+}
+
+// writeBitLookup emits xbm_bit(), which reports whether bitmap pixel p is
+// set. Pixels outside WIDTH × HEIGHT are never set.
+func writeBitLookup(buf shaderWriter, opts Options, img Image) {
+	if opts.Frames > 1 {
+		buf.WriteString("bool xbm_bit(ivec2 p, int frame) {\n")
+	} else {
+		buf.WriteString("bool xbm_bit(ivec2 p) {\n")
+	}
+	buf.WriteString("    if (p.x < 0 || p.y < 0 || p.x >= int(WIDTH) || p.y >= int(HEIGHT)) return false;\n")
+	if opts.Frames > 1 {
+		buf.WriteString("    p.y += frame * int(HEIGHT); // frames are stacked vertically\n")
+	}
+
+	switch opts.Mode {
+	case "texture":
+		// Bitmap texture (white = bit 1), fetched per texel
+		buf.WriteString("    return texelFetch(bitmap, p, 0).r > 0.5;\n")
+	case "itexture":
+		// Packed texture: each texel's red channel is one XBM byte (8 pixels),
+		// fetched as an unsigned integer
+		buf.WriteString(`    uint b = texelFetch(bitmap, ivec2(p.x >> 3, p.y), 0).r;
+    return ((b >> uint(p.x & 7)) & 1u) == 1u;
+`)
+	case "rle":
+		// Binary search for the number of run boundaries at or before idx;
+		// the value flips at each one, starting from unset.
+		run := "DATA[k]"
+		if rleHalf(img.Width * img.Height) {
+			run = "(DATA[k >> 1] >> uint((k & 1) * 16)) & 0xFFFFu"
+		}
+		fmt.Fprintf(buf, `    uint idx = uint(p.y * int(WIDTH) + p.x);
+    // Count the run boundaries at or before idx; each one flips the bit
+    int lo = 0;
+    int hi = RUNS;
+    while (lo < hi) {
+        int k = (lo + hi) >> 1;
+        if ((%s) <= idx) {
+            lo = k + 1;
+        } else {
+            hi = k;
+        }
+    }
+    return (lo & 1) == 1;
+`, run)
+	default:
+		buf.WriteString("    int idx = p.y * int(WIDTH) + p.x;\n")
+		words := opts.elements(len(img.Pack()))
+		if size := opts.chunkSize(words); size > 0 {
+			// DATA is split into chunk arrays; read the element first
+			switch opts.Pack {
+			case "int":
+				writeChunkRead(buf, "int", "idx >> 5", words, size)
+				buf.WriteString("    return ((w >> (idx & 31)) & 1) == 1;\n")
+			case "u64":
+				writeChunkRead(buf, "uvec2", "idx >> 6", words, size)
+				buf.WriteString("    return ((w[(idx >> 5) & 1] >> uint(idx & 31)) & 1u) == 1u;\n")
+			case "uvec4":
+				writeChunkRead(buf, "uvec4", "idx >> 7", words, size)
+				buf.WriteString("    return ((w[(idx >> 5) & 3] >> uint(idx & 31)) & 1u) == 1u;\n")
+			default:
+				writeChunkRead(buf, "uint", "idx >> 5", words, size)
+				buf.WriteString("    return ((w >> uint(idx & 31)) & 1u) == 1u;\n")
+			}
+			break
+		}
+		switch opts.Pack {
+		case "int":
+			buf.WriteString(`    int w = DATA[idx >> 5];
+    return ((w >> (idx & 31)) & 1) == 1;
+`)
+		case "u64":
+			// 64-bit words as uvec2: x holds the low 32 bits, y the high
+			buf.WriteString(`    uint w = DATA[idx >> 6][(idx >> 5) & 1];
+    return ((w >> uint(idx & 31)) & 1u) == 1u;
+`)
+		case "uvec4":
+			// Four 32-bit words per element
+			buf.WriteString(`    uint w = DATA[idx >> 7][(idx >> 5) & 3];
+    return ((w >> uint(idx & 31)) & 1u) == 1u;
+`)
+		default:
+			buf.WriteString(`    uint w = DATA[idx >> 5];
+    return ((w >> uint(idx & 31)) & 1u) == 1u;
+`)
+		}
+	}
+	buf.WriteString("}\n\n")
+}
+
+// reMaskSym matches the symbols of DATA and its lookup, which the copies
+// for Mask rename.
+var reMaskSym = regexp.MustCompile(`\b(DATA[0-9]*|xbm_bit)\b`)
+
+// writeMask emits MASK and xbm_mask(): DATA and xbm_bit() written for
+// mask instead of the image, packed and chunked the same way.
+func writeMask(buf shaderWriter, opts Options, mask Image) {
+	var code bytes.Buffer
+	writeData(&code, opts, mask.Pack(), mask.Width, mask.Height)
+	writeBitLookup(&code, opts, mask)
+	buf.WriteString("// Mask: pixels whose mask bit is clear are transparent\n")
+	buf.WriteString(reMaskSym.ReplaceAllStringFunc(code.String(), func(sym string) string {
+		if sym == "xbm_bit" {
+			return "xbm_mask"
+		}
+		return "MASK" + strings.TrimPrefix(sym, "DATA")
+	}))
+}
+
+// writeNearestSample emits the fragment code that sets v from the single
+// bitmap pixel under coord.
+func writeNearestSample(buf shaderWriter, opts Options, coord string) {
+	if opts.Scale > 1 {
+		buf.WriteString("    // Each bitmap pixel covers SCALE × SCALE cells\n")
+		fmt.Fprintf(buf, "    %s = floor(%s / float(SCALE));\n\n", coord, coord)
+	}
+
+	switch opts.Wrap {
+	case "clamp":
+		buf.WriteString("    // Outside the bitmap, repeat its edge pixels\n")
+		fmt.Fprintf(buf, "    int px = clamp(int(%s.x), 0, int(WIDTH) - 1);\n", coord)
+		fmt.Fprintf(buf, "    int py = clamp(int(%s.y), 0, int(HEIGHT) - 1);\n", coord)
+	case "once":
+		buf.WriteString("    // Draw once; xbm_bit() is false outside WIDTH × HEIGHT\n")
+		fmt.Fprintf(buf, "    int px = int(%s.x);\n", coord)
+		fmt.Fprintf(buf, "    int py = int(%s.y);\n", coord)
+	default:
+		buf.WriteString("    // Tile every WIDTH × HEIGHT pixels\n")
+		fmt.Fprintf(buf, "    int px = int(mod(%s.x, float(WIDTH)));\n", coord)
+		fmt.Fprintf(buf, "    int py = int(mod(%s.y, float(HEIGHT)));\n", coord)
+	}
+
+	buf.WriteString("    ivec2 p = ivec2(px, py);\n")
+	writeFrameSelect(buf, opts)
+	fmt.Fprintf(buf, "    bool on = %s;\n", bitCall(opts, "p"))
+	if opts.ZeroFG {
+		buf.WriteString("    float v = on ? 0.0 : 1.0; // bit 0 is foreground\n")
+	} else {
+		buf.WriteString("    float v = on ? 1.0 : 0.0;\n")
+	}
+}
+
+// writeSmoothSample emits the fragment code that sets v by blending the
+// four bitmap pixels nearest to coord (see writeSmoothLookup).
+func writeSmoothSample(buf shaderWriter, opts Options, coord string) {
+	pos := coord
+	if coord == "screen_px" {
+		pos = "screen_px + 0.5" // sample at the screen pixel centre
+	}
+	if opts.Scale > 1 {
+		buf.WriteString("    // Continuous bitmap position; each bitmap pixel covers SCALE × SCALE cells\n")
+		fmt.Fprintf(buf, "    vec2 pos = (%s) / float(SCALE);\n", pos)
+	} else {
+		buf.WriteString("    // Continuous bitmap position\n")
+		fmt.Fprintf(buf, "    vec2 pos = %s;\n", pos)
+	}
+	writeFrameSelect(buf, opts)
+	if opts.Frames > 1 {
+		fmt.Fprintf(buf, "    float v = %sxbm_smooth(pos, frame);\n", zeroFGPrefix(opts))
+	} else {
+		fmt.Fprintf(buf, "    float v = %sxbm_smooth(pos);\n", zeroFGPrefix(opts))
+	}
+}
+
+// zeroFGPrefix turns a blended bit value into foreground weight when bit 0
+// is the foreground.
+func zeroFGPrefix(opts Options) string {
+	if opts.ZeroFG {
+		return "1.0 - "
+	}
+	return ""
+}
+
+// writeCornerClip emits the fragment code for CornerRadius: a rounded box
+// distance of the fragment from the edge of its tile, outside which v is
+// cleared (faded over one pixel on the smooth path).
+func writeCornerClip(buf shaderWriter, opts Options, smooth bool) {
+	buf.WriteString("    // Round the tile's corners: background outside a rounded rectangle\n")
+	switch {
+	case !smooth:
+		buf.WriteString("    vec2 corner_p = vec2(float(px), float(py)) + 0.5;\n")
+	case opts.Wrap == "" || opts.Wrap == "tile":
+		buf.WriteString("    vec2 corner_p = mod(pos, vec2(float(WIDTH), float(HEIGHT)));\n")
+	default:
+		buf.WriteString("    vec2 corner_p = pos;\n")
+	}
+	buf.WriteString("    vec2 corner_h = vec2(float(WIDTH), float(HEIGHT)) * 0.5;\n")
+	fmt.Fprintf(buf, "    float corner_r = min(%s, min(corner_h.x, corner_h.y));\n", glslFloat(float64(opts.CornerRadius)))
+	buf.WriteString("    vec2 corner_q = abs(corner_p - corner_h) - corner_h + corner_r;\n")
+	buf.WriteString("    float corner_d = length(max(corner_q, 0.0)) + min(max(corner_q.x, corner_q.y), 0.0) - corner_r;\n")
+	if smooth {
+		buf.WriteString("    v *= clamp(0.5 - corner_d, 0.0, 1.0);\n")
+	} else {
+		buf.WriteString("    if (corner_d > 0.0) v = 0.0;\n")
+	}
+}
+
+// writeGradientPos emits the fragment code that sets grad to how far down
+// the bitmap (0 at the top row, 1 at the bottom) the fragment lies, for
+// BGGradient. The nearest path has the wrapped row py; the smooth path
+// wraps its continuous position the same way.
+func writeGradientPos(buf shaderWriter, opts Options, smooth bool) {
+	buf.WriteString("    // Background gradient position: 0 at the top row, 1 at the bottom\n")
+	switch {
+	case !smooth:
+		buf.WriteString("    float grad = clamp((float(py) + 0.5) / float(HEIGHT), 0.0, 1.0);\n")
+	case opts.Wrap == "" || opts.Wrap == "tile":
+		buf.WriteString("    float grad = fract(pos.y / float(HEIGHT));\n")
+	default:
+		buf.WriteString("    float grad = clamp(pos.y / float(HEIGHT), 0.0, 1.0);\n")
+	}
+}
+
+// writeFrameSelect emits the frame choice when Frames > 1: over TIME, or
+// by glyph_index for an atlas.
+func writeFrameSelect(buf shaderWriter, opts Options) {
+	if opts.Frames > 1 && opts.Atlas {
+		buf.WriteString(`
+    // Draw the stacked glyph chosen by glyph_index
+    int frame = clamp(glyph_index, 0, int(FRAMES) - 1);
+`)
+	} else if opts.Frames > 1 {
+		buf.WriteString(`
+    // Step through the stacked frames at fps
+    int frame = int(mod(floor(TIME * fps), float(FRAMES)));
+`)
+	} else {
+		buf.WriteString("\n")
+	}
+}
+
+// bitCall returns the GLSL call of xbm_bit() for bitmap coordinate p.
+func bitCall(opts Options, p string) string {
+	if opts.Frames > 1 {
+		return "xbm_bit(" + p + ", frame)"
+	}
+	return "xbm_bit(" + p + ")"
+}
+
+// maskCall is bitCall for xbm_mask(), the lookup of Mask.
+func maskCall(opts Options, p string) string {
+	return "xbm_mask" + strings.TrimPrefix(bitCall(opts, p), "xbm_bit")
+}
+
+// writeWrap emits xbm_wrap(), which applies the Wrap mode to a bitmap
+// coordinate for lookups away from the fragment's own pixel.
+func writeWrap(buf shaderWriter, opts Options) {
+	buf.WriteString("ivec2 xbm_wrap(ivec2 p) {\n")
+	switch opts.Wrap {
+	case "clamp":
+		buf.WriteString("    return clamp(p, ivec2(0), ivec2(int(WIDTH) - 1, int(HEIGHT) - 1));\n")
+	case "once":
+		buf.WriteString("    return p;\n")
+	default:
+		buf.WriteString("    return ivec2(int(mod(float(p.x), float(WIDTH))), int(mod(float(p.y), float(HEIGHT))));\n")
+	}
+	buf.WriteString("}\n\n")
+}
+
+// writeSmoothLookup emits xbm_smooth() for the smooth filter: a bilinear
+// blend of the four nearest bits, wrapped like the nearest-neighbour path.
+func writeSmoothLookup(buf shaderWriter, opts Options) {
+	if opts.Frames > 1 {
+		buf.WriteString("float xbm_smooth(vec2 pos, int frame) {\n")
+	} else {
+		buf.WriteString("float xbm_smooth(vec2 pos) {\n")
+	}
+	buf.WriteString(`    // Bit centres sit at +0.5; blend the 2×2 neighbourhood around pos
+    vec2 f = pos - 0.5;
+    ivec2 i = ivec2(floor(f));
+    vec2 t = fract(f);
+`)
+	for _, tap := range []struct{ name, off string }{
+		{"a", "i"}, {"b", "i + ivec2(1, 0)"}, {"c", "i + ivec2(0, 1)"}, {"d", "i + ivec2(1, 1)"},
+	} {
+		fmt.Fprintf(buf, "    float %s = %s ? 1.0 : 0.0;\n", tap.name, bitCall(opts, "xbm_wrap("+tap.off+")"))
+	}
+	buf.WriteString(`    return mix(mix(a, b, t.x), mix(c, d, t.x), t.y);
+}
+
+`)
+}
+
+// writeSDFLookup emits the sdf-mode xbm_smooth(): it samples the distance
+// field at pos (in bitmap pixels), applying Wrap, and smoothsteps across
+// the edge at 0.5. fwidth() keeps the ramp about one screen pixel wide
+// however far the bitmap is scaled.
+func writeSDFLookup(buf shaderWriter, opts Options) {
+	buf.WriteString(`float xbm_smooth(vec2 pos) {
+    vec2 size = vec2(float(WIDTH), float(HEIGHT));
+`)
+	switch opts.Wrap {
+	case "clamp":
+		buf.WriteString("    vec2 uv = clamp(pos, vec2(0.5), size - 0.5) / size;\n")
+	case "once":
+		buf.WriteString(`    if (any(lessThan(pos, vec2(0.0))) || any(greaterThanEqual(pos, size))) return 0.0;
+    vec2 uv = pos / size;
+`)
+	default:
+		buf.WriteString("    vec2 uv = fract(pos / size);\n")
+	}
+	buf.WriteString(`    // The edge is at 0.5; blend over about one screen pixel
+    float d = texture(bitmap, uv).r;
+    float w = max(fwidth(d) * 0.5, 0.0001);
+    return smoothstep(0.5 - w, 0.5 + w, d);
+}
+
+`)
+}
+
+// writeRotate emits xbm_rotate(), which turns a point by the rotation
+// uniform around the centre of one (scaled) tile.
+func writeRotate(buf shaderWriter, opts Options) {
+	buf.WriteString("vec2 xbm_rotate(vec2 q) {\n")
+	if opts.Scale > 1 {
+		buf.WriteString("    vec2 centre = vec2(float(WIDTH * SCALE), float(HEIGHT * SCALE)) * 0.5;\n")
+	} else {
+		buf.WriteString("    vec2 centre = vec2(float(WIDTH), float(HEIGHT)) * 0.5;\n")
+	}
+	buf.WriteString(`    float c = cos(rotation);
+    float s = sin(rotation);
+    return mat2(vec2(c, s), vec2(-s, c)) * (q - centre) + centre;
+}
+
+`)
+}
+
+// writeColorLookup emits xbm_color(), which turns an int colour uniform
+// (ColorFormat "int") into the vec4 the float format would have held.
+func writeColorLookup(buf shaderWriter, opts Options) {
+	buf.WriteString("vec4 xbm_color(ivec4 c) {\n")
+	if !opts.linear() {
+		buf.WriteString("    return vec4(c) / 255.0;\n}\n\n")
+		return
+	}
+	buf.WriteString(`    vec4 s = vec4(c) / 255.0;
+    // sRGB to linear light; alpha is never converted
+    vec3 lo = s.rgb / 12.92;
+    vec3 hi = pow((s.rgb + 0.055) / 1.055, vec3(2.4));
+    return vec4(mix(hi, lo, vec3(lessThanEqual(s.rgb, vec3(0.04045)))), s.a);
+}
+
+`)
+}
+
+// writeOutline emits the fragment code that paints background pixels
+// touching a foreground pixel (4-neighbourhood) in outline_color. It works
+// on the displayed image, so a runtime invert moves the outline too.
+func writeOutline(buf shaderWriter, opts Options) {
+	n := opts.names()
+	nb := func(off string) string {
+		e := bitCall(opts, "xbm_wrap(p + "+off+")")
+		switch {
+		case !opts.NoInvertUniform && opts.ZeroFG:
+			e = "(" + e + " == " + n.invert + ")"
+		case !opts.NoInvertUniform:
+			e = "(" + e + " != " + n.invert + ")"
+		case opts.ZeroFG:
+			e = "!" + e
+		}
+		return e
+	}
+	buf.WriteString("\n    // Outline background pixels next to a foreground pixel\n")
+	buf.WriteString("    if (v < 0.5) {\n")
+	fmt.Fprintf(buf, "        bool edge = %s || %s\n", nb("ivec2(1, 0)"), nb("ivec2(-1, 0)"))
+	fmt.Fprintf(buf, "            || %s || %s;\n", nb("ivec2(0, 1)"), nb("ivec2(0, -1)"))
+	if opts.DiscardBG {
+		fmt.Fprintf(buf, "        if (edge) col = %s; else discard;\n", opts.colorRef("outline_color"))
+	} else {
+		fmt.Fprintf(buf, "        if (edge) col = %s;\n", opts.colorRef("outline_color"))
+	}
+	buf.WriteString("    }\n\n")
+}
+
+// writeCenter emits the shift that puts the bitmap (SCALE times its size)
+// in the middle of the node's rect for Center. UV spans the rect, so its
+// change per screen pixel gives the rect size in pixels; the pixel
+// coordinates then count from the rect's corner instead of the screen's,
+// rounded to whole pixels so the pattern stays pixel-locked.
+func writeCenter(buf shaderWriter, opts Options) {
+	size := "vec2(float(WIDTH), float(HEIGHT))"
+	if opts.Scale > 1 {
+		size += " * float(SCALE)"
+	}
+	buf.WriteString("    // Centre the bitmap in the rect: shift by half the space left around it\n")
+	buf.WriteString("    vec2 rect_size = round(1.0 / abs(vec2(dFdx(UV.x), dFdy(UV.y))));\n")
+	fmt.Fprintf(buf, "    screen_px = floor(UV * rect_size) - floor((rect_size - %s) / 2.0);\n\n", size)
+}
+
+// writeFragment emits fragment(): map the fragment to an integer bitmap
+// coordinate, look up the bit and write the mixed colour.
+func writeFragment(buf shaderWriter, opts Options) {
+	buf.WriteString("void fragment() {\n")
+
+	smooth := opts.Filter == "smooth"
+	coord := "screen_px"
+	if opts.Tile != [2]int{} {
+		// Stretched: tile_repeat copies of the bitmap across the screen or mesh
+		src, what := "SCREEN_UV", "screen"
+		coord = "tile_px"
+		if opts.UVSource == "uv" {
+			src, what, coord = "UV", "mesh", "uv_px"
+		}
+		fmt.Fprintf(buf, "    // Repeat the bitmap tile_repeat times across the %s\n", what)
+		if smooth {
+			fmt.Fprintf(buf, "    vec2 %s = %s * vec2(tile_repeat) * vec2(float(WIDTH), float(HEIGHT));\n\n", coord, src)
+		} else {
+			fmt.Fprintf(buf, "    vec2 %s = floor(%s * vec2(tile_repeat) * vec2(float(WIDTH), float(HEIGHT)));\n\n", coord, src)
+		}
+	} else if opts.UVSource == "uv" {
+		// UV-mapped: the bitmap follows the mesh instead of the screen
+		coord = "uv_px"
+		buf.WriteString("    // Scale mesh UV (0..1) so each bitmap pixel covers 1/WIDTH × 1/HEIGHT\n")
+		if smooth {
+			buf.WriteString("    vec2 uv_px = UV * vec2(float(WIDTH), float(HEIGHT));\n\n")
+		} else {
+			buf.WriteString("    vec2 uv_px = floor(UV * vec2(float(WIDTH), float(HEIGHT)));\n\n")
+		}
+	} else if opts.Godot == 3 {
+		// Godot 3 only exposes SCREEN_PIXEL_SIZE to canvas_item; FRAGCOORD works everywhere
+		buf.WriteString(`    // Integer screen pixel coords of this fragment
+    vec2 screen_px = floor(FRAGCOORD.xy);
+
+`)
+	} else {
+		// Pixel-perfect tiling (screen-locked)
+		buf.WriteString(`    // Convert normalized screen UV (0..1) into integer screen pixel coords
+    vec2 screen_px = floor(SCREEN_UV / SCREEN_PIXEL_SIZE);
+
+`)
+	}
+
+	if opts.Center {
+		writeCenter(buf, opts)
+	}
+
+	if opts.EmitRotation {
+		// Rotate the pixel centre; the nearest path snaps back to a pixel
+		buf.WriteString("    // Rotate around the tile centre by the rotation uniform (radians)\n")
+		switch {
+		case !smooth:
+			fmt.Fprintf(buf, "    %s = floor(xbm_rotate(%s + 0.5));\n\n", coord, coord)
+		case coord == "screen_px":
+			fmt.Fprintf(buf, "    %s = xbm_rotate(%s + 0.5) - 0.5;\n\n", coord, coord)
+		default:
+			fmt.Fprintf(buf, "    %s = xbm_rotate(%s);\n\n", coord, coord)
+		}
+	}
+
+	if smooth {
+		writeSmoothSample(buf, opts, coord)
+	} else {
+		writeNearestSample(buf, opts, coord)
+	}
+
+	n := opts.names()
+	if !opts.NoInvertUniform {
+		fmt.Fprintf(buf, "    if (%s) v = 1.0 - v;\n", n.invert)
+	}
+	if opts.CornerRadius > 0 {
+		writeCornerClip(buf, opts, smooth)
+	}
+
+	fg := opts.colorRef(n.fg)
+	if opts.RespectModulate && opts.ShaderType == "canvas_item" {
+		// Tint the foreground with the node's modulate
+		fg += " * COLOR"
+	}
+	if opts.EmitBlink {
+		// Pulse the foreground's alpha; blink_hz 0 keeps it steady
+		fmt.Fprintf(buf, "    vec4 fg_col = %s;\n", fg)
+		buf.WriteString("    if (blink_hz > 0.0) fg_col.a *= 0.5 + 0.5 * sin(TIME * blink_hz * 6.2831853);\n")
+		fg = "fg_col"
+	}
+	bg := opts.colorRef(n.bg)
+	switch {
+	case opts.overTexture():
+		bg = "texture(TEXTURE, UV)"
+	case opts.gradient():
+		writeGradientPos(buf, opts, smooth)
+		bg = fmt.Sprintf("mix(%s, %s, grad)", opts.colorRef(n.bg+"_top"), opts.colorRef(n.bg+"_bottom"))
+	}
+	fmt.Fprintf(buf, "    vec4 col = mix(%s, %s, v);\n", bg, fg)
+	if opts.Outline != "" {
+		writeOutline(buf, opts)
+	} else if opts.DiscardBG {
+		// Drop background fragments instead of blending them in (after invert)
+		if smooth {
+			buf.WriteString("    if (v <= 0.0) discard;\n")
+		} else {
+			buf.WriteString("    if (v < 0.5) discard;\n")
+		}
+	}
+	if opts.Mask != nil {
+		// The mask hides pixels whatever the image, invert and outline say
+		buf.WriteString("\n    // Transparent where the mask bit is clear\n")
+		if opts.DiscardBG {
+			fmt.Fprintf(buf, "    if (!%s) discard;\n", maskCall(opts, "p"))
+		} else {
+			fmt.Fprintf(buf, "    if (!%s) {\n        v = 0.0;\n        col.a = 0.0;\n    }\n", maskCall(opts, "p"))
+		}
+	}
+
+	switch {
+	case opts.Mode == "visualshader":
+		// Expression node outputs, wired to the output node by the resource
+		if opts.ShaderType == "canvas_item" || opts.Channel != "alpha" {
+			buf.WriteString("    xbm_rgb = col.rgb;\n")
+		}
+		switch {
+		case opts.ShaderType == "spatial" && opts.Channel == "alpha":
+			buf.WriteString("    xbm_alpha = v;\n")
+		case opts.ShaderType == "canvas_item" || opts.Channel != "albedo":
+			buf.WriteString("    xbm_alpha = col.a;\n")
+		}
+	case opts.ShaderType == "canvas_item":
+		buf.WriteString("    COLOR = col;\n")
+	case opts.Channel == "albedo":
+		// Spatial variant, colour only; alpha is left to the material
+		buf.WriteString("    ALBEDO = col.rgb;\n")
+	case opts.Channel == "alpha":
+		// Spatial variant as a mask: opaque on foreground only
+		buf.WriteString("    ALPHA = v;\n")
+	default:
+		// Spatial variant: ALBEDO/ALPHA
+		buf.WriteString("    ALBEDO = col.rgb;\n")
+		buf.WriteString("    ALPHA  = col.a;\n")
+	}
+	buf.WriteString("}\n")
+}
@@ -0,0 +1,180 @@
+package xbm
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// pattern returns a w×h image with an irregular mix of set and clear
+// pixels, including bit 31 of several words.
+func pattern(w, h int) Image {
+	img := newImage(w, h)
+	for i := range w * h {
+		if (i*7+i/5)%3 == 0 || i%32 == 31 {
+			img.set(i%w, i/w)
+		}
+	}
+	return img
+}
+
+var reDataWord = regexp.MustCompile(`0x[0-9A-F]+u|\(-2147483647 - 1\)|-?\b[0-9]+u?\b`)
+
+// dataWords returns the 32-bit words of the DATA array in shader,
+// whatever element type it is written in.
+func dataWords(t *testing.T, shader string) []uint32 {
+	t.Helper()
+	start := strings.Index(shader, "DATA[WORDS] = ")
+	if start < 0 {
+		t.Fatal("no DATA array in shader")
+	}
+	body := shader[start:]
+	body = body[strings.Index(body, "[](")+3 : strings.Index(body, ");")]
+	var words []uint32
+	for _, lit := range reDataWord.FindAllString(body, -1) {
+		var v int64
+		var err error
+		switch {
+		case strings.HasPrefix(lit, "0x"):
+			v, err = strconv.ParseInt(strings.TrimSuffix(lit[2:], "u"), 16, 64)
+		case strings.HasPrefix(lit, "("):
+			v = -2147483648
+		default:
+			v, err = strconv.ParseInt(strings.TrimSuffix(lit, "u"), 10, 64)
+		}
+		if err != nil {
+			t.Fatalf("DATA element %q: %v", lit, err)
+		}
+		words = append(words, uint32(v))
+	}
+	return words
+}
+
+func TestPackLookups(t *testing.T) {
+	// 13 words, so the last u64 and uvec4 elements are zero-padded
+	img := pattern(37, 11)
+
+	// Each case mirrors the xbm_bit() body BuildShader writes for it
+	tests := []struct {
+		pack   string
+		lookup string
+		bit    func(words []uint32, idx int) bool
+	}{
+		{"uint", "DATA[idx >> 5];", func(words []uint32, idx int) bool {
+			w := words[idx>>5]
+			return (w>>uint(idx&31))&1 == 1
+		}},
+		{"int", "int w = DATA[idx >> 5];", func(words []uint32, idx int) bool {
+			w := int32(words[idx>>5])
+			return (w>>(idx&31))&1 == 1
+		}},
+		{"u64", "DATA[idx >> 6][(idx >> 5) & 1];", func(words []uint32, idx int) bool {
+			elem := words[(idx>>6)*2 : (idx>>6)*2+2]
+			w := elem[(idx>>5)&1]
+			return (w>>uint(idx&31))&1 == 1
+		}},
+		{"uvec4", "DATA[idx >> 7][(idx >> 5) & 3];", func(words []uint32, idx int) bool {
+			elem := words[(idx>>7)*4 : (idx>>7)*4+4]
+			w := elem[(idx>>5)&3]
+			return (w>>uint(idx&31))&1 == 1
+		}},
+	}
+	for _, tt := range tests {
+		shader, err := BuildShader(img, Options{ShaderType: "canvas_item", FG: "#000000FF", BG: "#00000000", Pack: tt.pack})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.pack, err)
+		}
+		if !strings.Contains(shader, tt.lookup) {
+			t.Fatalf("%s: xbm_bit() does not read %q", tt.pack, tt.lookup)
+		}
+		words := dataWords(t, shader)
+		for y := range img.Height {
+			for x := range img.Width {
+				if got, want := tt.bit(words, y*img.Width+x), img.At(x, y); got != want {
+					t.Errorf("%s: pixel (%d,%d) = %t, want %t", tt.pack, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestBuildShaderDeterministic(t *testing.T) {
+	img := pattern(23, 9)
+	for _, opts := range []Options{
+		{ShaderType: "canvas_item", FG: "#336699", BG: "#ffffff80"},
+		{ShaderType: "spatial", FG: "teal", Pack: "uvec4"},
+		{ShaderType: "canvas_item", Scale: 3, Outline: "#ff0000"},
+		{ShaderType: "canvas_item", Scale: 2, Filter: "smooth", Wrap: "once"},
+	} {
+		first, err := BuildShader(img, opts)
+		if err != nil {
+			t.Fatalf("%+v: %v", opts, err)
+		}
+		second, err := BuildShader(img, opts)
+		if err != nil {
+			t.Fatalf("%+v: %v", opts, err)
+		}
+		if first != second {
+			t.Errorf("%+v: two builds differ:\n%s\nthen:\n%s", opts, first, second)
+		}
+	}
+}
+
+func TestITextureSampler(t *testing.T) {
+	// The packed bytes are read as integers, not rescaled floats
+	for _, godot := range []int{3, 4} {
+		shader, err := BuildShader(pattern(20, 3), Options{ShaderType: "canvas_item", Mode: "itexture", Godot: godot})
+		if err != nil {
+			t.Fatalf("godot %d: %v", godot, err)
+		}
+		for _, want := range []string{"uniform usampler2D bitmap", "uint b = texelFetch(bitmap, ivec2(p.x >> 3, p.y), 0).r;"} {
+			if !strings.Contains(shader, want) {
+				t.Errorf("godot %d: shader lacks %q", godot, want)
+			}
+		}
+		if strings.Contains(shader, "255.0") {
+			t.Errorf("godot %d: shader still rescales the texel", godot)
+		}
+	}
+}
+
+// goldenXBM is the fixed input of the golden-file tests: an 11×5 arrow,
+// so rows have padding bits and the data spans two words.
+const goldenXBM = `#define arrow_width 11
+#define arrow_height 5
+static unsigned char arrow_bits[] = {
+   0x20, 0x00, 0x60, 0x00, 0xff, 0x00, 0x60, 0x00, 0x20, 0x00 };
+`
+
+func TestBuildShaderGolden(t *testing.T) {
+	img, err := Parse([]byte(goldenXBM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, typ := range []string{"canvas_item", "spatial"} {
+		got, err := BuildShader(img, Options{ShaderType: typ, FG: "#FF8000", BG: "#00000000"})
+		if err != nil {
+			t.Fatalf("%s: %v", typ, err)
+		}
+		path := filepath.Join("testdata", typ+".gdshader.golden")
+		if *update {
+			if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("%v (run go test -update to create it)", err)
+		}
+		if got != string(want) {
+			t.Errorf("%s: shader differs from %s (run go test -update if intended):\n%s", typ, path, got)
+		}
+	}
+}
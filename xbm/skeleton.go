@@ -0,0 +1,71 @@
+package xbm
+
+// Skeleton collects the text of an XBM source written to it in pieces,
+// leaving out the values between braces but keeping any comments there.
+// What remains stays small however large the bitmap is, and ColorHints and
+// ArrayNames read it as they would the whole source. Use it alongside
+// ParseReader, which otherwise keeps none of the text.
+type Skeleton struct {
+	text    []byte
+	depth   int  // braces open outside comments
+	comment byte // '*' in a block comment, '/' in a line comment, else 0
+	star    bool // the last byte of a block comment was '*'
+	slash   bool // the last byte outside comments was '/'
+}
+
+// Write adds p to the source. It never fails.
+func (s *Skeleton) Write(p []byte) (int, error) {
+	for _, b := range p {
+		s.add(b)
+	}
+	return len(p), nil
+}
+
+// Bytes returns the text kept so far.
+func (s *Skeleton) Bytes() []byte {
+	return s.text
+}
+
+func (s *Skeleton) add(b byte) {
+	switch s.comment {
+	case '*':
+		s.text = append(s.text, b)
+		if s.star && b == '/' {
+			s.comment = 0
+		}
+		s.star = b == '*'
+		return
+	case '/':
+		s.text = append(s.text, b)
+		if b == '\n' {
+			s.comment = 0
+		}
+		return
+	}
+
+	if s.slash {
+		s.slash = false
+		if b == '*' || b == '/' {
+			if s.depth > 0 {
+				s.text = append(s.text, '/') // held back by the case below
+			}
+			s.comment, s.star = b, false
+			s.text = append(s.text, b)
+			return
+		}
+	}
+	switch {
+	case b == '/':
+		// A comment may follow; between braces the '/' is kept only then
+		if s.slash = true; s.depth > 0 {
+			return
+		}
+	case b == '{':
+		s.depth++
+	case b == '}' && s.depth > 0:
+		s.depth--
+	case s.depth > 0:
+		return // a value or separator
+	}
+	s.text = append(s.text, b)
+}
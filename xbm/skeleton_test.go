@@ -0,0 +1,32 @@
+package xbm
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSkeleton(t *testing.T) {
+	src := "/* fg: red */\n#define a_width 8\n#define a_height 2\n" +
+		"static char a_bits[] = {\n  0x01, /* bg: #00ff00 */ 0x02 // } 0x03\n};\n" +
+		"static char b_bits[] = { 0x04, 0x05 };\n"
+	want := "/* fg: red */\n#define a_width 8\n#define a_height 2\n" +
+		"static char a_bits[] = {/* bg: #00ff00 */// } 0x03\n};\n" +
+		"static char b_bits[] = {};\n"
+
+	// Write a byte at a time so every state crosses a write boundary
+	var s Skeleton
+	for i := range len(src) {
+		s.Write([]byte{src[i]})
+	}
+	if got := string(s.Bytes()); got != want {
+		t.Fatalf("Skeleton = %q, want %q", got, want)
+	}
+
+	fg, bg := ColorHints(s.Bytes())
+	if fg != "red" || bg != "#00ff00" {
+		t.Errorf("ColorHints(skeleton) = %q, %q, want red, #00ff00", fg, bg)
+	}
+	if got := ArrayNames(s.Bytes()); !slices.Equal(got, []string{"a", "b"}) {
+		t.Errorf("ArrayNames(skeleton) = %q, want [a b]", got)
+	}
+}
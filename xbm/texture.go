@@ -0,0 +1,103 @@
+package xbm
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// WritePNG encodes img as an 8-bit grayscale PNG for texture mode:
+// set bits are white, clear bits are black. The colours themselves stay
+// in the shader uniforms so fg/bg/invert keep working at runtime.
+func WritePNG(w io.Writer, img Image) error {
+	g := image.NewGray(image.Rect(0, 0, img.Width, img.Height))
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			if img.At(x, y) {
+				g.SetGray(x, y, color.Gray{Y: 0xFF})
+			}
+		}
+	}
+	return png.Encode(w, g)
+}
+
+// WritePackedPNG encodes img for itexture mode: an 8-bit grayscale PNG of
+// ((Width+7)/8)×Height texels where each texel is one XBM byte, LSB-first
+// regardless of img.BitOrder.
+func WritePackedPNG(w io.Writer, img Image) error {
+	rowBytes := (img.Width + 7) / 8
+	g := image.NewGray(image.Rect(0, 0, rowBytes, img.Height))
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			if img.At(x, y) {
+				i := g.PixOffset(x>>3, y)
+				g.Pix[i] |= 1 << uint(x&7)
+			}
+		}
+	}
+	return png.Encode(w, g)
+}
+
+// TextureImport returns a Godot .import file for a texture-mode PNG that
+// keeps it lossless and unfiltered: no mipmaps, no VRAM compression and no
+// sRGB conversion, so every texel reads back as the exact byte written.
+// Godot fills in the source path and uid on first import; only the
+// [params] matter. Godot 4 sets nearest filtering on the sampler (the
+// shader's filter_nearest hint), Godot 3 on the texture (flags/filter).
+func TextureImport(godot int) string {
+	if godot == 3 {
+		return `[remap]
+
+importer="texture"
+type="StreamTexture"
+
+[params]
+
+compress/mode=0
+compress/lossy_quality=0.7
+compress/hdr_mode=0
+compress/bptc_ldr=0
+compress/normal_map=0
+flags/repeat=0
+flags/filter=false
+flags/mipmaps=false
+flags/anisotropic=false
+flags/srgb=0
+process/fix_alpha_border=false
+process/premult_alpha=false
+process/HDR_as_SRGB=false
+process/invert_color=false
+process/normal_map_invert_y=false
+stream=false
+size_limit=0
+detect_3d=false
+svg/scale=1.0
+`
+	}
+	return `[remap]
+
+importer="texture"
+type="CompressedTexture2D"
+
+[params]
+
+compress/mode=0
+compress/high_quality=false
+compress/lossy_quality=0.7
+compress/hdr_compression=1
+compress/normal_map=0
+compress/channel_pack=0
+mipmaps/generate=false
+mipmaps/limit=-1
+roughness/mode=0
+roughness/src_normal=""
+process/fix_alpha_border=false
+process/premult_alpha=false
+process/normal_map_invert_y=false
+process/hdr_as_srgb=false
+process/hdr_clamp_exposure=false
+process/size_limit=0
+detect_3d/compress_to=0
+`
+}
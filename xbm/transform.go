@@ -0,0 +1,179 @@
+package xbm
+
+import "fmt"
+
+// newImage returns a blank LSB-first image of the given size.
+func newImage(w, h int) Image {
+	return Image{Width: w, Height: h, Bits: make([]byte, ((w+7)/8)*h)}
+}
+
+// set turns on the pixel at (x, y) of an LSB-first image.
+func (img Image) set(x, y int) {
+	img.Bits[y*((img.Width+7)/8)+(x>>3)] |= 1 << uint(x&7)
+}
+
+// Invert returns a copy of img with every pixel flipped. Row padding bits
+// stay clear, and the result is always LSBFirst.
+func Invert(img Image) Image {
+	dst := newImage(img.Width, img.Height)
+	dst.XHot, dst.YHot = img.XHot, img.YHot
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			if !img.At(x, y) {
+				dst.set(x, y)
+			}
+		}
+	}
+	return dst
+}
+
+// Flip returns a copy of img mirrored left-right (flipX) and/or
+// top-bottom (flipY). It works on the pixel grid, so row padding is
+// unaffected; the hotspot is mirrored too. The result is always LSBFirst.
+func Flip(img Image, flipX, flipY bool) Image {
+	dst := newImage(img.Width, img.Height)
+	dst.XHot, dst.YHot = img.XHot, img.YHot
+	if flipX {
+		dst.XHot = img.Width - 1 - img.XHot
+	}
+	if flipY {
+		dst.YHot = img.Height - 1 - img.YHot
+	}
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			if !img.At(x, y) {
+				continue
+			}
+			dx, dy := x, y
+			if flipX {
+				dx = img.Width - 1 - x
+			}
+			if flipY {
+				dy = img.Height - 1 - y
+			}
+			dst.set(dx, dy)
+		}
+	}
+	return dst
+}
+
+// Rotate returns a copy of img turned clockwise by degrees, which must be
+// 0, 90, 180 or 270. Quarter turns swap the width and height. The hotspot
+// turns with the image, and the result is always LSBFirst.
+func Rotate(img Image, degrees int) (Image, error) {
+	var dst Image
+	var at func(x, y int) (int, int)
+	switch degrees {
+	case 0, 180:
+		dst = newImage(img.Width, img.Height)
+		at = func(x, y int) (int, int) { return x, y }
+		if degrees == 180 {
+			at = func(x, y int) (int, int) { return img.Width - 1 - x, img.Height - 1 - y }
+		}
+	case 90:
+		dst = newImage(img.Height, img.Width)
+		at = func(x, y int) (int, int) { return img.Height - 1 - y, x }
+	case 270:
+		dst = newImage(img.Height, img.Width)
+		at = func(x, y int) (int, int) { return y, img.Width - 1 - x }
+	default:
+		return Image{}, fmt.Errorf("rotation must be 0, 90, 180 or 270 degrees, got %d", degrees)
+	}
+
+	dst.XHot, dst.YHot = at(img.XHot, img.YHot)
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			if img.At(x, y) {
+				dst.set(at(x, y))
+			}
+		}
+	}
+	return dst, nil
+}
+
+// Downsample shrinks img by factor in both directions, turning each
+// factor×factor block into one pixel. A block is set if at least half of
+// its pixels are (a majority vote), or with anyPixel if any of them is,
+// which keeps one-pixel lines. Blocks cut off at the right and bottom
+// edges vote with the pixels they have. The hotspot scales with the image,
+// and the result is always LSBFirst.
+func Downsample(img Image, factor int, anyPixel bool) (Image, error) {
+	if factor < 1 {
+		return Image{}, fmt.Errorf("downsample factor must be at least 1, got %d", factor)
+	}
+	dst := newImage((img.Width+factor-1)/factor, (img.Height+factor-1)/factor)
+	dst.XHot, dst.YHot = img.XHot/factor, img.YHot/factor
+	for by := 0; by < dst.Height; by++ {
+		for bx := 0; bx < dst.Width; bx++ {
+			on, n := 0, 0
+			for y := by * factor; y < min((by+1)*factor, img.Height); y++ {
+				for x := bx * factor; x < min((bx+1)*factor, img.Width); x++ {
+					if img.At(x, y) {
+						on++
+					}
+					n++
+				}
+			}
+			if (anyPixel && on > 0) || (!anyPixel && 2*on >= n) {
+				dst.set(bx, by)
+			}
+		}
+	}
+	return dst, nil
+}
+
+// Stack places same-sized images on top of each other, first at the top,
+// producing an atlas for Options.Frames/Atlas. The hotspot is the first
+// image's. The result is always LSBFirst.
+func Stack(imgs ...Image) (Image, error) {
+	if len(imgs) == 0 {
+		return Image{}, fmt.Errorf("nothing to stack")
+	}
+	w, h := imgs[0].Width, imgs[0].Height
+	dst := newImage(w, h*len(imgs))
+	dst.XHot, dst.YHot = imgs[0].XHot, imgs[0].YHot
+	for i, img := range imgs {
+		if img.Width != w || img.Height != h {
+			return Image{}, fmt.Errorf("image %d is %dx%d, want %dx%d like the first", i+1, img.Width, img.Height, w, h)
+		}
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				if img.At(x, y) {
+					dst.set(x, i*h+y)
+				}
+			}
+		}
+	}
+	return dst, nil
+}
+
+// Trim crops img to the bounding box of its set pixels and returns the
+// result with the box's top-left corner in the original image. The hotspot
+// moves with the crop. An image with no set pixels becomes a 1×1 blank at
+// (0, 0). The result is always LSBFirst.
+func Trim(img Image) (dst Image, x0, y0 int) {
+	x0, y0 = img.Width, img.Height
+	x1, y1 := -1, -1
+	for y := 0; y < img.Height; y++ {
+		for x := 0; x < img.Width; x++ {
+			if img.At(x, y) {
+				x0, y0 = min(x0, x), min(y0, y)
+				x1, y1 = max(x1, x), max(y1, y)
+			}
+		}
+	}
+	if x1 < 0 {
+		return newImage(1, 1), 0, 0
+	}
+
+	dst = newImage(x1-x0+1, y1-y0+1)
+	dst.XHot, dst.YHot = img.XHot-x0, img.YHot-y0
+	for y := y0; y <= y1; y++ {
+		for x := x0; x <= x1; x++ {
+			if img.At(x, y) {
+				dst.set(x-x0, y-y0)
+			}
+		}
+	}
+	return dst, x0, y0
+}
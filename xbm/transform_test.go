@@ -0,0 +1,121 @@
+package xbm
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// grid builds an image from rows of '#' (set) and '.' (clear) pixels.
+func grid(rows ...string) Image {
+	img := newImage(len(rows[0]), len(rows))
+	for y, row := range rows {
+		for x, c := range row {
+			if c == '#' {
+				img.set(x, y)
+			}
+		}
+	}
+	return img
+}
+
+// rows renders img back into the form grid reads.
+func rows(img Image) []string {
+	out := make([]string, img.Height)
+	for y := range out {
+		var b strings.Builder
+		for x := 0; x < img.Width; x++ {
+			if img.At(x, y) {
+				b.WriteByte('#')
+			} else {
+				b.WriteByte('.')
+			}
+		}
+		out[y] = b.String()
+	}
+	return out
+}
+
+func TestInvert(t *testing.T) {
+	// 11 pixels wide, so each row has five padding bits
+	src := grid(
+		"#.#..##.#.#",
+		"...........",
+		"##########.",
+	)
+	for _, order := range []BitOrder{LSBFirst, MSBFirst} {
+		img := src
+		if order == MSBFirst {
+			img = Image{Width: src.Width, Height: src.Height, Bits: make([]byte, len(src.Bits)), BitOrder: MSBFirst}
+			for i, b := range src.Bits {
+				for k := 0; k < 8; k++ {
+					img.Bits[i] |= (b >> uint(k) & 1) << uint(7-k)
+				}
+			}
+		}
+		inv := Invert(img)
+		for y := range img.Height {
+			for x := range img.Width {
+				if inv.At(x, y) == img.At(x, y) {
+					t.Errorf("order %d: pixel (%d,%d) not inverted", order, x, y)
+				}
+			}
+		}
+		n := img.Width * img.Height
+		data := inv.Pack()
+		// Bits past the image stay clear in the last word
+		if rest := data[len(data)-1] >> uint(n%32); n%32 != 0 && rest != 0 {
+			t.Errorf("order %d: bits past the image set: %#x", order, rest)
+		}
+	}
+}
+
+func TestFlip(t *testing.T) {
+	src := grid(
+		"##.",
+		"..#",
+	)
+	tests := []struct {
+		flipX, flipY bool
+		want         []string
+	}{
+		{false, false, []string{"##.", "..#"}},
+		{true, false, []string{".##", "#.."}},
+		{false, true, []string{"..#", "##."}},
+		{true, true, []string{"#..", ".##"}},
+	}
+	for _, tt := range tests {
+		if got := rows(Flip(src, tt.flipX, tt.flipY)); !slices.Equal(got, tt.want) {
+			t.Errorf("Flip(x=%t, y=%t) = %q, want %q", tt.flipX, tt.flipY, got, tt.want)
+		}
+	}
+}
+
+func TestRotate(t *testing.T) {
+	src := grid(
+		"#.",
+		"#.",
+		"##",
+	)
+	tests := []struct {
+		degrees int
+		want    []string
+	}{
+		{0, []string{"#.", "#.", "##"}},
+		{90, []string{"###", "#.."}},
+		{180, []string{"##", ".#", ".#"}},
+		{270, []string{"..#", "###"}},
+	}
+	for _, tt := range tests {
+		img, err := Rotate(src, tt.degrees)
+		if err != nil {
+			t.Fatalf("Rotate(%d): %v", tt.degrees, err)
+		}
+		if got := rows(img); !slices.Equal(got, tt.want) {
+			t.Errorf("Rotate(%d) = %q, want %q", tt.degrees, got, tt.want)
+		}
+	}
+	if _, err := Rotate(src, 45); err == nil {
+		t.Error("Rotate(45): got no error")
+	}
+}
@@ -0,0 +1,66 @@
+package xbm
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// buildVisualShader wraps the array-mode shader in a VisualShader resource
+// (.tres, Godot 4.1 or later) of two nodes: a global expression holding
+// everything above fragment() and an expression node holding its body,
+// whose xbm_rgb and xbm_alpha outputs feed the output node's colour (or
+// albedo) and alpha ports.
+func buildVisualShader(opts Options, img Image, fg, bg string) string {
+	code := buildShader(opts, img, fg, bg)
+	decl := fmt.Sprintf("shader_type %s;\n\n", opts.ShaderType)
+	i := strings.Index(code, decl)
+	j := strings.Index(code, "void fragment() {\n")
+	global := code[:i] + code[i+len(decl):j]
+	body := strings.TrimSuffix(code[j+len("void fragment() {\n"):], "}\n")
+	body = strings.ReplaceAll(strings.TrimPrefix(body, "    "), "\n    ", "\n")
+
+	// Output ports as "index,type,name;": type 4 is a vec3, 0 a float.
+	// Each is connected to the output node port of the same meaning.
+	var ports, conns []string
+	port := func(typ int, name string, out int) {
+		k := len(ports)
+		ports = append(ports, fmt.Sprintf("%d,%d,%s%s;", k, typ, opts.Prefix, name))
+		conns = append(conns, fmt.Sprintf("3, %d, 0, %d", k, out))
+	}
+	if opts.ShaderType == "canvas_item" || opts.Channel != "alpha" {
+		port(4, "xbm_rgb", 0) // COLOR.rgb or ALBEDO
+	}
+	if opts.ShaderType == "canvas_item" || opts.Channel != "albedo" {
+		port(0, "xbm_alpha", 1) // COLOR.a or ALPHA
+	}
+	mode := 0 // Shader.MODE_SPATIAL
+	if opts.ShaderType == "canvas_item" {
+		mode = 1
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("[gd_resource type=\"VisualShader\" load_steps=3 format=3]\n\n")
+	buf.WriteString("[sub_resource type=\"VisualShaderNodeGlobalExpression\" id=\"1\"]\n")
+	buf.WriteString("size = Vector2(640, 480)\n")
+	fmt.Fprintf(&buf, "expression = %s\n\n", tresString(global))
+	buf.WriteString("[sub_resource type=\"VisualShaderNodeExpression\" id=\"2\"]\n")
+	buf.WriteString("size = Vector2(560, 420)\n")
+	fmt.Fprintf(&buf, "output_ports = %q\n", strings.Join(ports, ""))
+	fmt.Fprintf(&buf, "expression = %s\n\n", tresString(body))
+	buf.WriteString("[resource]\n")
+	fmt.Fprintf(&buf, "mode = %d\n", mode)
+	buf.WriteString("nodes/fragment/0/position = Vector2(700, 140)\n")
+	buf.WriteString("nodes/fragment/2/node = SubResource(\"1\")\n")
+	buf.WriteString("nodes/fragment/2/position = Vector2(-1040, 140)\n")
+	buf.WriteString("nodes/fragment/3/node = SubResource(\"2\")\n")
+	buf.WriteString("nodes/fragment/3/position = Vector2(-300, 140)\n")
+	fmt.Fprintf(&buf, "nodes/fragment/connections = PackedInt32Array(%s)\n", strings.Join(conns, ", "))
+	return buf.String()
+}
+
+// tresString quotes s as a Godot text resource string, which may span
+// lines; only backslashes and double quotes are escaped.
+func tresString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,494 @@
+// Package xbm parses X BitMap (XBM) images and converts them into
+// self-contained Godot 4 shaders.
+package xbm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// Width/height #defines (any symbol prefix)
+	reW = regexp.MustCompile(`(?m)#define\s+\w+_width\s+(\d+)`)
+	reH = regexp.MustCompile(`(?m)#define\s+\w+_height\s+(\d+)`)
+
+	// Optional cursor hotspot #defines
+	reXHot = regexp.MustCompile(`(?m)#define\s+\w+_x_hot\s+(-?\d+)`)
+	reYHot = regexp.MustCompile(`(?m)#define\s+\w+_y_hot\s+(-?\d+)`)
+
+	// Permissive: find the start of "<name>_bits[] = {" (any qualifiers,
+	// type or declared size); the scanner finds the matching brace.
+	// reArrAt only matches one that ends the text read so far.
+	reArrStart = regexp.MustCompile(`([A-Za-z_]\w*)_bits\s*\[[^\]]*\]\s*=\s*\{`)
+	reArrAt    = regexp.MustCompile(reArrStart.String() + `$`)
+
+	// C block and line comments
+	reCComment = regexp.MustCompile(`(?s)/\*.*?\*/|//[^\n]*`)
+)
+
+// Image is a parsed XBM bitmap.
+type Image struct {
+	Width  int
+	Height int
+	// Bits holds the raw XBM bytes: rows are byte-padded and, for the
+	// default LSBFirst order, the least significant bit of each byte is
+	// the leftmost pixel.
+	Bits     []byte
+	BitOrder BitOrder
+	// XHot and YHot are the cursor hotspot from the _x_hot/_y_hot
+	// #defines, or (0, 0) if the file has none.
+	XHot, YHot int
+}
+
+// DecodeOptions tunes Decode for formats that need extra input.
+type DecodeOptions struct {
+	// Threshold is the luminance cutoff (1-255) for raster images; see
+	// ParseRaster. Zero means 128.
+	Threshold int
+	// Unit declares the element type of an XBM bits array: "char" keeps
+	// every value as one byte, "short" splits every value into two
+	// little-endian bytes (with rows padded to 16 bits). Empty takes it
+	// from the array's declaration (char, short, uint8_t, uint16_t, ...),
+	// and failing that guesses per value: anything above 0xFF is taken as
+	// a short.
+	Unit string
+	// WidthDefine and HeightDefine name the exact #define symbols holding
+	// an XBM's size, for generators that do not follow the <name>_width /
+	// <name>_height convention (e.g. "IMAGE_W"). Empty means the usual
+	// suffix match.
+	WidthDefine  string
+	HeightDefine string
+	// Format forces the input format instead of detecting it (see Format):
+	// "xbm", "xpm", "pbm", "raster", "ico" or "ascii". ASCII grids (see
+	// ParseASCII) have no signature, so they are only read when forced.
+	Format string
+	// Array picks the bits array of an XBM file that holds several (such
+	// as an X11 cursor and its mask) by name, with or without the _bits
+	// suffix; its own <name>_width/<name>_height #defines are used when
+	// present. Empty means the first array. See ArrayNames.
+	Array string
+	// OnChar is the character drawn as foreground in an ASCII grid. Zero
+	// means '#'.
+	OnChar rune
+}
+
+// Format returns the input format Decode would use for src: "xpm", "pbm",
+// "raster" (PNG, GIF or JPEG), "ico" (Windows icon or cursor) or "xbm".
+func Format(src []byte) string {
+	switch {
+	case isXPM(src):
+		return "xpm"
+	case isPBM(src):
+		return "pbm"
+	case isRaster(src):
+		return "raster"
+	case isICO(src):
+		return "ico"
+	}
+	return "xbm"
+}
+
+// Decode detects the input format by content (see Format) and parses it.
+func Decode(src []byte) (Image, error) {
+	return DecodeWith(src, DecodeOptions{})
+}
+
+// DecodeWith is Decode with format-specific options.
+func DecodeWith(src []byte, opts DecodeOptions) (Image, error) {
+	format := opts.Format
+	if format == "" {
+		format = Format(src)
+	}
+	switch format {
+	case "xbm":
+		return ParseWith(src, opts)
+	case "xpm":
+		return ParseXPM(src)
+	case "pbm":
+		return ParsePBM(src)
+	case "raster":
+		return ParseRaster(src, opts.Threshold)
+	case "ico":
+		return ParseICO(src)
+	case "ascii":
+		return ParseASCII(src, opts.OnChar)
+	}
+	return Image{}, fmt.Errorf("unknown format %q (want xbm, xpm, pbm, raster, ico or ascii)", format)
+}
+
+// utf8BOM is the byte order mark some Windows editors prepend to text files.
+const utf8BOM = "\ufeff"
+
+// normalizeText strips a leading UTF-8 BOM and turns CRLF and lone CR line
+// endings into LF, so files saved on Windows (or classic Mac OS) parse like
+// any other.
+func normalizeText(s string) string {
+	s = strings.TrimPrefix(s, utf8BOM)
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.ReplaceAll(s, "\r", "\n")
+}
+
+// joinContinuations removes backslash-newline pairs, as the C
+// preprocessor does, so continued lines read as one. Line endings must
+// already be normalized.
+func joinContinuations(s string) string {
+	return strings.ReplaceAll(s, "\\\n", "")
+}
+
+// stripComments blanks out C comments so numbers (or whole #defines)
+// inside them are not taken for image data. Each comment becomes a space,
+// keeping neighbouring tokens apart.
+func stripComments(s string) string {
+	return reCComment.ReplaceAllString(s, " ")
+}
+
+// Parse reads the width/height #defines and the bits array of an XBM file.
+func Parse(src []byte) (Image, error) {
+	return ParseWith(src, DecodeOptions{})
+}
+
+// ParseWith is Parse honouring the XBM-specific fields of opts.
+func ParseWith(src []byte, opts DecodeOptions) (Image, error) {
+	return ParseReader(bytes.NewReader(src), opts)
+}
+
+// ParseReader is ParseWith reading from r. Only the text around the bits
+// array is buffered; the array itself is decoded as it is read, so memory
+// use stays close to the size of the bitmap (not of its source text) for
+// large files.
+func ParseReader(r io.Reader, opts DecodeOptions) (Image, error) {
+	return parseXBM(r, opts, &Info{})
+}
+
+// parseXBM implements ParseReader, recording in info what it finds for
+// Probe, as far as it gets.
+func parseXBM(r io.Reader, opts DecodeOptions, info *Info) (Image, error) {
+	info.Format = "xbm"
+	switch opts.Unit {
+	case "", "char", "short":
+	default:
+		return Image{}, fmt.Errorf("unknown unit %q (want char or short)", opts.Unit)
+	}
+	t := newTextReader(r)
+
+	// Read up to the opening brace of the bits array, leaving comments
+	// out of head as they are met. Each brace is checked against the
+	// declaration it ends, the text since the last ';', '{' or '}'; arrays
+	// other than opts.Array are skipped.
+	want := strings.TrimSuffix(opts.Array, "_bits")
+	unit := opts.Unit // or the declared element type, or a guess per value
+	var head []byte
+	decl := 0          // start of the current declaration in head
+	var names []string // arrays seen, for the error when want is missing
+	found := false
+	for !found {
+		c, ok := t.next()
+		if !ok {
+			break
+		}
+		if c == '/' {
+			d, _ := t.peek()
+			if t.skipComment() {
+				// A line comment takes its newline with it
+				c = ' '
+				if d == '/' {
+					c = '\n'
+				}
+			}
+		}
+		head = append(head, c)
+		if c != '{' {
+			if c == ';' || c == '}' {
+				decl = len(head)
+			}
+			continue
+		}
+		d := string(head[decl:])
+		decl = len(head)
+		m := reArrAt.FindStringSubmatchIndex(d)
+		if m == nil {
+			continue
+		}
+		name := d[m[2]:m[3]]
+		names = append(names, name)
+		if found = want == "" || name == want; found {
+			info.Array, info.UnitFrom = name, "option"
+			if unit == "" {
+				unit = declaredUnit(d[:m[0]])
+				info.UnitFrom = "declaration"
+			}
+		} else {
+			if closed, err := t.scanBits(func(int64) {}); err != nil {
+				return Image{}, err
+			} else if !closed {
+				break
+			}
+		}
+	}
+	s := string(head)
+	if !found && want != "" && t.err == nil {
+		if len(names) == 0 {
+			return Image{}, ErrNoBits
+		}
+		return Image{}, fmt.Errorf("%w: no array %s_bits (found %s)", ErrNoBits, want, strings.Join(names, ", "))
+	}
+
+	// The size (and hotspot) #defines of a chosen array are looked up by
+	// its name first, then as for a single-image file
+	define := func(s string, re *regexp.Regexp, exact, suffix string) []string {
+		if exact == "" && want != "" {
+			if m := findDefine(s, re, want+suffix); m != nil {
+				return m
+			}
+		}
+		return findDefine(s, re, exact)
+	}
+
+	// Build raw byte stream. With no declared unit, a value > 0xFF is assumed
+	// to be 16-bit little-endian (common for short-based XBM).
+	var out []byte
+	if wm, hm := define(s, reW, opts.WidthDefine, "_width"), define(s, reH, opts.HeightDefine, "_height"); wm != nil && hm != nil {
+		w, werr := strconv.Atoi(wm[1])
+		h, herr := strconv.Atoi(hm[1])
+		// Room for short rows too, unless the #defines are out of reach:
+		// then the array grows as its values arrive
+		if n, ok := rasterBytes(w, h, 16); werr == nil && herr == nil && ok && n <= maxPrealloc {
+			out = make([]byte, 0, n)
+		}
+	}
+	closed, numErr := false, error(nil)
+	chars, shorts := 0, 0 // values taken as one byte and as two, for Probe
+	if found {
+		closed, numErr = t.scanBits(func(v int64) {
+			info.Values++
+			switch {
+			case unit == "short":
+				out = append(out, byte(v&0xFF), byte((v>>8)&0xFF))
+			case unit == "char" || v <= 0xFF:
+				out = append(out, byte(v))
+				chars++
+			default:
+				out = append(out, byte(v&0xFF), byte((v>>8)&0xFF))
+				shorts++
+			}
+		})
+	}
+	info.Bytes = len(out)
+	if info.Unit = unit; found && unit == "" {
+		info.Unit, info.UnitFrom = "char", "values"
+		switch {
+		case shorts > 0 && chars > 0:
+			info.Unit = "mixed"
+		case shorts > 0:
+			info.Unit = "short"
+		}
+	}
+	if closed {
+		// #defines may also follow the array
+		var tail []byte
+		for c, ok := t.next(); ok; c, ok = t.next() {
+			tail = append(tail, c)
+		}
+		s += " " + stripComments(string(tail))
+	}
+	if t.err != nil {
+		return Image{}, t.err
+	}
+
+	wm := define(s, reW, opts.WidthDefine, "_width")
+	hm := define(s, reH, opts.HeightDefine, "_height")
+	switch {
+	case wm == nil && opts.WidthDefine != "":
+		return Image{}, fmt.Errorf("%w: no #define %s", ErrNoDefines, opts.WidthDefine)
+	case hm == nil && opts.HeightDefine != "":
+		return Image{}, fmt.Errorf("%w: no #define %s", ErrNoDefines, opts.HeightDefine)
+	case wm == nil || hm == nil:
+		return Image{}, ErrNoDefines
+	}
+	if numErr != nil {
+		return Image{}, numErr
+	}
+	if !closed {
+		return Image{}, ErrNoBits
+	}
+	w, werr := strconv.Atoi(wm[1])
+	h, herr := strconv.Atoi(hm[1])
+	info.Width, info.Height = w, h
+	if _, ok := rasterBytes(w, h, 16); werr != nil || herr != nil || !ok {
+		return Image{}, fmt.Errorf("#defines give %sx%s, which is too large", wm[1], hm[1])
+	}
+	info.Want, _ = rasterBytes(w, h, 8)
+	if unit == "short" {
+		info.Want, _ = rasterBytes(w, h, 16)
+	}
+	if w == 0 || h == 0 {
+		return Image{}, fmt.Errorf("%w: #defines give %dx%d", ErrZeroSize, w, h)
+	}
+	if len(out) == 0 {
+		return Image{}, ErrEmptyBits
+	}
+
+	if unit == "short" {
+		var err error
+		if out, err = unpadShortRows(out, w, h); err != nil {
+			return Image{}, err
+		}
+	}
+	if err := checkLength(out, w, h); err != nil {
+		return Image{}, err
+	}
+	img := Image{Width: w, Height: h, Bits: out}
+	if m := define(s, reXHot, "", "_x_hot"); m != nil {
+		img.XHot, _ = strconv.Atoi(m[1])
+	}
+	if m := define(s, reYHot, "", "_y_hot"); m != nil {
+		img.YHot, _ = strconv.Atoi(m[1])
+	}
+	return img, nil
+}
+
+// declaredUnit returns the unit ("char" or "short") named by the type in
+// decl, the declaration text before a bits array's name, or "" when it
+// names neither (e.g. "static int" or none at all).
+func declaredUnit(decl string) string {
+	for _, word := range strings.Fields(decl) {
+		switch word {
+		case "char", "uint8_t", "int8_t", "u_char":
+			return "char"
+		case "short", "uint16_t", "int16_t", "u_short":
+			return "short"
+		}
+	}
+	return ""
+}
+
+// ArrayNames lists the bits arrays of an XBM source in order, named
+// without their _bits suffix, for choosing one with DecodeOptions.Array.
+func ArrayNames(src []byte) []string {
+	s := stripComments(joinContinuations(normalizeText(string(src))))
+	var names []string
+	for _, m := range reArrStart.FindAllStringSubmatch(s, -1) {
+		names = append(names, m[1])
+	}
+	return names
+}
+
+// findDefine matches the numeric #define named name in s, or re when name
+// is empty. The submatch holds the value.
+func findDefine(s string, re *regexp.Regexp, name string) []string {
+	if name != "" {
+		re = regexp.MustCompile(`(?m)#define\s+` + regexp.QuoteMeta(name) + `\s+(\d+)`)
+	}
+	return re.FindStringSubmatch(s)
+}
+
+// unpadShortRows converts rows padded to 16 bits (X10 short arrays) into
+// the byte-padded layout used everywhere else. Anything past the last row
+// is kept, so checkLength still sees (and rejects) surplus values.
+func unpadShortRows(bits []byte, w, h int) ([]byte, error) {
+	rowBytes := (w + 7) / 8
+	shortRow := ((w + 15) / 16) * 2
+	if rowBytes == shortRow {
+		return bits, nil
+	}
+	if len(bits) < shortRow*h {
+		return nil, fmt.Errorf("%w: got %d bytes, want %d (%d rows of %d shorts for %dx%d)",
+			ErrShortBits, len(bits), shortRow*h, h, shortRow/2, w, h)
+	}
+	out := make([]byte, 0, rowBytes*h)
+	for y := 0; y < h; y++ {
+		out = append(out, bits[y*shortRow:y*shortRow+rowBytes]...)
+	}
+	return append(out, bits[shortRow*h:]...), nil
+}
+
+// maxPrealloc caps the bytes parseXBM reserves for the bits array up front
+// from the #defines, so a bogus size cannot allocate more than that before
+// the array itself is read.
+const maxPrealloc = 16 << 20
+
+// rasterBytes returns the size of h rows of w pixels, each padded to a
+// multiple of pad bits (8 or 16), and false if that does not fit in an int.
+func rasterBytes(w, h, pad int) (int, bool) {
+	if w < 0 || h < 0 || w > math.MaxInt-pad {
+		return 0, false
+	}
+	row := (w + pad - 1) / pad * (pad / 8)
+	if h > 0 && row > math.MaxInt/h {
+		return 0, false
+	}
+	return row * h, true
+}
+
+// checkLength verifies the bits array covers ((w+7)/8)*h bytes. Missing
+// bytes are always an error (the file is truncated or the #defines are
+// wrong); surplus bytes are tolerated only if they are zero padding.
+func checkLength(bits []byte, w, h int) error {
+	rowBytes := (w + 7) / 8
+	want := rowBytes * h
+	if len(bits) < want {
+		return fmt.Errorf("%w: got %d bytes, want %d (%d rows of %d bytes for %dx%d)",
+			ErrShortBits, len(bits), want, h, rowBytes, w, h)
+	}
+	for _, b := range bits[want:] {
+		if b != 0 {
+			return fmt.Errorf("%w: got %d bytes, want %d (%d rows of %d bytes for %dx%d)",
+				ErrLongBits, len(bits), want, h, rowBytes, w, h)
+		}
+	}
+	return nil
+}
+
+// At reports whether the pixel at (x, y) is set. Coordinates outside the
+// image (or past the end of Bits) read as unset.
+func (img Image) At(x, y int) bool {
+	if x < 0 || y < 0 || x >= img.Width || y >= img.Height {
+		return false
+	}
+	bi := y*((img.Width+7)/8) + (x >> 3)
+	if bi >= len(img.Bits) {
+		return false
+	}
+	shift := uint(x & 7)
+	if img.BitOrder == MSBFirst {
+		shift = uint(7 - (x & 7))
+	}
+	return (img.Bits[bi]>>shift)&1 == 1
+}
+
+// PaddingRows counts the rows whose padding bits (past Width, up to the
+// byte boundary) are set. Those bits are never drawn; a non-zero count
+// hints that the array was packed for a wider image.
+func (img Image) PaddingRows() int {
+	if img.Width%8 == 0 {
+		return 0
+	}
+	rowBytes := (img.Width + 7) / 8
+	used := byte(1)<<uint(img.Width%8) - 1 // low bits are pixels, LSB first
+	if img.BitOrder == MSBFirst {
+		used = ^(0xFF >> uint(img.Width%8))
+	}
+	n := 0
+	for y := 0; y < img.Height; y++ {
+		i := y*rowBytes + rowBytes - 1
+		if i < len(img.Bits) && img.Bits[i]&^used != 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// Coverage returns the fraction (0..1) of pixels that are set.
+func (img Image) Coverage() float64 {
+	return Coverage(img.Pack(), img.Width, img.Height)
+}
+
+// Pack repacks the image into the tight 32-bit words used by the shader.
+func (img Image) Pack() []uint32 {
+	return RepackBitsToU32(img.Bits, img.Width, img.Height, img.BitOrder)
+}
@@ -0,0 +1,254 @@
+package xbm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseCommentedValue(t *testing.T) {
+	// Without comment stripping the trailing values would be extra bytes
+	src := `#define c_width 8
+#define c_height 2
+static char c_bits[] = {
+  0x01, 0x02 /* , 0xff */
+  // , 0x33
+};
+`
+	img, err := Parse([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0x01, 0x02}; !bytes.Equal(img.Bits, want) {
+		t.Errorf("Bits = %#x, want %#x", img.Bits, want)
+	}
+}
+
+func TestParseCommentedHead(t *testing.T) {
+	// Arrays and braces inside comments are not declarations, and a line
+	// comment keeps the next #define on its own line
+	src := `/* old: static char c_bits[] = { 0xff }; */
+#define c_width 8 // was 16
+#define c_height 1
+// static short c_bits[] = {
+static /* { */ char c_bits[] = { 0x81 };
+`
+	img, err := Parse([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Width != 8 || img.Height != 1 || !bytes.Equal(img.Bits, []byte{0x81}) {
+		t.Errorf("got %dx%d %#x, want 8x1 0x81", img.Width, img.Height, img.Bits)
+	}
+}
+
+func TestParseMalformedWhitespace(t *testing.T) {
+	tests := []struct {
+		name, src string
+	}{
+		{"continuations", "#define m_width 8\n#define m_height 3\nstatic char m_bits[] = { 0x01, \\\n  0x02, \\\n  0x03 };\n"},
+		{"tabs", "#define m_width 8\n#define m_height 3\nstatic char m_bits[] = {\t0x01,\t\t0x02 ,\n\t0x03\t};\n"},
+		{"brace in comment", "#define m_width 8\n#define m_height 3\nstatic char m_bits[] = { 0x01, /* } */ 0x02,\n  // }\n  0x03 };\n"},
+		{"continued define", "#define m_width \\\n  8\n#define m_height 3\nstatic char m_bits[] = { 0x01, 0x02, 0x03 };\n"},
+	}
+	for _, tt := range tests {
+		img, err := Parse([]byte(tt.src))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if want := []byte{0x01, 0x02, 0x03}; img.Width != 8 || img.Height != 3 || !bytes.Equal(img.Bits, want) {
+			t.Errorf("%s: got %dx%d %#x, want 8x3 %#x", tt.name, img.Width, img.Height, img.Bits, want)
+		}
+	}
+}
+
+func TestParseCRLFBOM(t *testing.T) {
+	lf := "/* fg: red */\n#define e_width 12\n#define e_height 2\n#define e_x_hot 3\nstatic unsigned char e_bits[] = {\n  0x0f, 0x08, 0xf0, 0x01 };\n"
+	crlf := "\ufeff" + strings.ReplaceAll(lf, "\n", "\r\n")
+
+	opts := Options{ShaderType: "canvas_item"}
+	var shaders [2]string
+	for i, src := range []string{lf, crlf} {
+		img, err := Parse([]byte(src))
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", src, err)
+		}
+		if shaders[i], err = BuildShader(img, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if shaders[0] != shaders[1] {
+		t.Errorf("CRLF+BOM shader differs from LF:\n%s\nwant:\n%s", shaders[1], shaders[0])
+	}
+}
+
+func TestParseDeclaredUnit(t *testing.T) {
+	tests := []struct {
+		name, decl, values string
+		want               []byte
+	}{
+		// Small values in a short array still take two bytes each
+		{"short", "static unsigned short u_bits[]", "0x0001, 0x0002", []byte{0x01, 0x00, 0x02, 0x00}},
+		// Each char value is one byte, where shorts would double the rows
+		{"char", "static unsigned char u_bits[]", "0x01, 0x02, 0x03, 0x04", []byte{0x01, 0x02, 0x03, 0x04}},
+		// No type: values above 0xFF are taken as shorts
+		{"guessed", "static u_bits[]", "0x0201, 0x0403", []byte{0x01, 0x02, 0x03, 0x04}},
+	}
+	for _, tt := range tests {
+		src := "#define u_width 16\n#define u_height 2\n" + tt.decl + " = { " + tt.values + " };\n"
+		img, err := Parse([]byte(src))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(img.Bits, tt.want) {
+			t.Errorf("%s: Bits = %#x, want %#x", tt.name, img.Bits, tt.want)
+		}
+	}
+}
+
+func TestParseZeroSize(t *testing.T) {
+	for _, src := range []string{
+		"#define x_width 0\n#define x_height 2\nstatic char x_bits[] = { 0x00, 0x00 };\n",
+		"#define x_width 8\n#define x_height 0\nstatic char x_bits[] = { 0x00 };\n",
+	} {
+		if _, err := Parse([]byte(src)); !errors.Is(err, ErrZeroSize) {
+			t.Errorf("Parse(%q) = %v, want ErrZeroSize", src, err)
+		}
+	}
+}
+
+func TestParseBinaryLiterals(t *testing.T) {
+	const head = "#define b_width 12\n#define b_height 2\nstatic unsigned char b_bits[] = {\n"
+	hex, err := Parse([]byte(head + "  0xA5, 0x0F, 0x3C, 0x09 };\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin, err := Parse([]byte(head + "  0b10100101, 0B1111, 0b00111100, 0b1001 };\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := bin.Pack(), hex.Pack(); !slices.Equal(got, want) {
+		t.Errorf("binary literals pack to %#x, hex to %#x", got, want)
+	}
+}
+
+func TestParseHugeDefines(t *testing.T) {
+	// Sizes that overflow, or that the array cannot fill, must fail
+	// without allocating for them
+	for _, size := range [][2]string{
+		{"99999999999999999999", "1"},
+		{"4611686018427387904", "4611686018427387904"},
+		{"1000000000", "1000000000"},
+	} {
+		src := "#define h_width " + size[0] + "\n#define h_height " + size[1] + "\nstatic char h_bits[] = { 0x01, 0x02 };\n"
+		if _, err := Parse([]byte(src)); err == nil {
+			t.Errorf("Parse(%s×%s): got no error", size[0], size[1])
+		}
+	}
+}
+
+func TestParseNegativeValues(t *testing.T) {
+	// Negative values are clamped to 0, not read without their sign
+	img, err := Parse([]byte("#define n_width 8\n#define n_height 4\nstatic char n_bits[] = { -1, - 0x0F, -/* x */3, 0x05 };\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0x00, 0x00, 0x00, 0x05}; !bytes.Equal(img.Bits, want) {
+		t.Errorf("Bits = %#x, want %#x", img.Bits, want)
+	}
+}
+
+// bigXBM returns the text of a w×h XBM file laid out as X11 writes them,
+// twelve bytes to a line.
+func bigXBM(w, h int) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "#define big_width %d\n#define big_height %d\nstatic unsigned char big_bits[] = {\n", w, h)
+	n := (w + 7) / 8 * h
+	for i := range n {
+		sep := ", "
+		switch {
+		case i == n-1:
+			sep = " };\n"
+		case i%12 == 11:
+			sep = ",\n"
+		}
+		fmt.Fprintf(&b, "0x%02x%s", byte(i*37), sep)
+	}
+	return b.Bytes()
+}
+
+var reTokNum = regexp.MustCompile(`0[xX][0-9A-Fa-f]+|\d+`)
+
+// parseTokenized decodes the bits array the way Parse did before it
+// streamed: the whole cleaned-up text in memory, then every number in the
+// array body as a string. It is the baseline for the benchmarks.
+func parseTokenized(src []byte) ([]byte, error) {
+	s := stripComments(joinContinuations(normalizeText(string(src))))
+	loc := reArrStart.FindStringIndex(s)
+	if loc == nil {
+		return nil, ErrNoBits
+	}
+	end := strings.IndexByte(s[loc[1]:], '}')
+	if end < 0 {
+		return nil, ErrNoBits
+	}
+	nums := reTokNum.FindAllString(s[loc[1]:loc[1]+end], -1)
+	out := make([]byte, 0, len(nums))
+	for _, t := range nums {
+		base := 10
+		if strings.HasPrefix(t, "0x") || strings.HasPrefix(t, "0X") {
+			t, base = t[2:], 16
+		}
+		v, err := strconv.ParseUint(t, base, 8)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, byte(v))
+	}
+	return out, nil
+}
+
+func BenchmarkParse(b *testing.B) {
+	src := bigXBM(4096, 4096)
+	b.SetBytes(int64(len(src)))
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := Parse(src); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseReader(b *testing.B) {
+	src := bigXBM(4096, 4096)
+	b.SetBytes(int64(len(src)))
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := ParseReader(bytes.NewReader(src), DecodeOptions{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseTokenized(b *testing.B) {
+	src := bigXBM(4096, 4096)
+	img, err := Parse(src)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if bits, err := parseTokenized(src); err != nil || !bytes.Equal(bits, img.Bits) {
+		b.Fatalf("baseline disagrees with Parse: %v", err)
+	}
+	b.SetBytes(int64(len(src)))
+	b.ReportAllocs()
+	for b.Loop() {
+		parseTokenized(src)
+	}
+}
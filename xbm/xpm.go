@@ -0,0 +1,163 @@
+package xbm
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// reStr matches the C string literals that carry all XPM data.
+var reStr = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"`)
+
+// isXPM reports whether src starts with the XPM magic comment.
+func isXPM(src []byte) bool {
+	src = bytes.TrimPrefix(src, []byte(utf8BOM))
+	return bytes.HasPrefix(bytes.TrimSpace(src), []byte("/* XPM */"))
+}
+
+// ParseXPM reads a two-colour XPM (X PixMap) into a bitmap. The darker
+// colour becomes the foreground (set) bit; a "None" colour is always the
+// background. Images with more than two colours are rejected.
+func ParseXPM(src []byte) (Image, error) {
+	var strs []string
+	for _, m := range reStr.FindAllSubmatch(src, -1) {
+		strs = append(strs, string(m[1]))
+	}
+	if len(strs) == 0 {
+		return Image{}, fmt.Errorf("xpm: no data strings found")
+	}
+
+	// Header: "<width> <height> <ncolors> <chars per pixel> [x_hot y_hot]"
+	hdr := strings.Fields(strs[0])
+	if len(hdr) < 4 {
+		return Image{}, fmt.Errorf("xpm: bad header %q", strs[0])
+	}
+	var vals [4]int
+	for i := range vals {
+		v, err := strconv.Atoi(hdr[i])
+		if err != nil || v <= 0 {
+			return Image{}, fmt.Errorf("xpm: bad header %q", strs[0])
+		}
+		vals[i] = v
+	}
+	w, h, ncolors, cpp := vals[0], vals[1], vals[2], vals[3]
+	if ncolors > 2 {
+		return Image{}, fmt.Errorf("xpm: %d colours: %w", ncolors, ErrTooManyColors)
+	}
+	if ncolors > len(strs) || h > len(strs) || len(strs) < 1+ncolors+h {
+		return Image{}, fmt.Errorf("xpm: want %d colours and %d rows, got %d strings", ncolors, h, len(strs)-1)
+	}
+
+	// Colour table: "<chars> c <colour>" (other visuals such as m/g/s are ignored
+	// unless there is no c entry).
+	type entry struct {
+		key  string
+		lum  int // 0..255*1000, -1 for None
+		none bool
+	}
+	entries := make([]entry, ncolors)
+	for i := range entries {
+		line := strs[1+i]
+		if len(line) < cpp {
+			return Image{}, fmt.Errorf("xpm: bad colour line %q", line)
+		}
+		spec, err := xpmColor(line[cpp:])
+		if err != nil {
+			return Image{}, err
+		}
+		e := entry{key: line[:cpp]}
+		if strings.EqualFold(spec, "none") {
+			e.none, e.lum = true, -1
+		} else {
+			c, err := xpmParseColor(spec)
+			if err != nil {
+				return Image{}, err
+			}
+			e.lum = 299*int(c.R) + 587*int(c.G) + 114*int(c.B)
+		}
+		entries[i] = e
+	}
+
+	// Foreground is the darker opaque colour (XBM 'black').
+	fg := 0
+	for i, e := range entries {
+		if !e.none && (entries[fg].none || e.lum < entries[fg].lum) {
+			fg = i
+		}
+	}
+	fgKey := entries[fg].key
+	if entries[fg].none {
+		fgKey = "" // nothing is drawn
+	}
+
+	// Check every row against the header before allocating for it; the
+	// division keeps a huge width from overflowing w*cpp
+	rows := strs[1+ncolors : 1+ncolors+h]
+	for y, row := range rows {
+		if len(row)/cpp < w {
+			return Image{}, fmt.Errorf("xpm: row %d has %d pixels, want %d", y, len(row)/cpp, w)
+		}
+	}
+
+	img := newImage(w, h)
+	if len(hdr) >= 6 {
+		img.XHot, _ = strconv.Atoi(hdr[4])
+		img.YHot, _ = strconv.Atoi(hdr[5])
+	}
+	for y, row := range rows {
+		for x := 0; x < w; x++ {
+			if fgKey != "" && row[x*cpp:(x+1)*cpp] == fgKey {
+				img.set(x, y)
+			}
+		}
+	}
+	return img, nil
+}
+
+// xpmColor returns the colour value of the c (or failing that m/g/g4)
+// visual in the key/value part of a colour table line.
+func xpmColor(spec string) (string, error) {
+	f := strings.Fields(spec)
+	found := map[string]string{}
+	for i := 0; i+1 < len(f); i++ {
+		switch f[i] {
+		case "c", "m", "g", "g4", "s":
+			// Values may contain spaces ("light grey"); read up to the next key.
+			j := i + 1
+			for j < len(f) && !isXPMKey(f[j]) {
+				j++
+			}
+			found[f[i]] = strings.Join(f[i+1:j], " ")
+			i = j - 1
+		}
+	}
+	for _, k := range []string{"c", "m", "g", "g4"} {
+		if v, ok := found[k]; ok {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("xpm: no colour in %q", spec)
+}
+
+func isXPMKey(s string) bool {
+	switch s {
+	case "c", "m", "g", "g4", "s":
+		return true
+	}
+	return false
+}
+
+// xpmParseColor accepts the colour names and hex forms used by XPM,
+// including X11's 12-digit #RRRRGGGGBBBB.
+func xpmParseColor(spec string) (Color, error) {
+	if strings.HasPrefix(spec, "#") && len(spec) == 13 {
+		spec = "#" + spec[1:3] + spec[5:7] + spec[9:11]
+	}
+	c, err := ParseColor(spec)
+	if err != nil {
+		return Color{}, fmt.Errorf("xpm: unsupported colour %q", spec)
+	}
+	return c, nil
+}
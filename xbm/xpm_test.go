@@ -0,0 +1,35 @@
+package xbm
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseXPM(t *testing.T) {
+	src := []byte(`/* XPM */
+static char *x[] = {
+"3 2 2 1",
+"# c #000000",
+". c None",
+"#.#",
+".#.",
+};
+`)
+	img, err := ParseXPM(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rows(img), []string{"#.#", ".#."}; !slices.Equal(got, want) {
+		t.Errorf("ParseXPM = %q, want %q", got, want)
+	}
+}
+
+func TestParseXPMHugeSize(t *testing.T) {
+	// Headers far larger than the pixel rows must fail before allocating
+	for _, hdr := range []string{"1000000000000000000 2 2 1", "3 1000000000000000000 2 1", "1000000000000000000 2 2 100"} {
+		src := []byte("/* XPM */\nstatic char *x[] = {\n\"" + hdr + "\",\n\"# c #000000\",\n\". c None\",\n\"#.#\",\n\".#.\",\n};\n")
+		if _, err := ParseXPM(src); err == nil {
+			t.Errorf("ParseXPM with header %q: got no error", hdr)
+		}
+	}
+}